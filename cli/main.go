@@ -21,8 +21,10 @@ package main
 import (
 	"MonitorEncoder/core/activetime"
 	"MonitorEncoder/core/common"
+	"MonitorEncoder/core/notify"
 	"MonitorEncoder/core/status"
 	"MonitorEncoder/core/worker"
+	"MonitorEncoder/core/worker/branch"
 	"MonitorEncoder/core/worker/final"
 	"MonitorEncoder/core/worker/misc"
 	"MonitorEncoder/core/worker/monitor"
@@ -45,12 +47,18 @@ import (
 func main() {
 	param := common.Parameter{}
 	flag.IntVar(&param.WorkerNum, "n", 1, "worker num")
+	flag.IntVar(&param.MiscJobNum, "mj", 2, "max concurrent audio/demux jobs per task")
 	flag.StringVar(&param.MonitorDirPath, "md", "monitor_dir", "monitor dir")
 	flag.StringVar(&param.WorkDirPath, "wd", "work_dir", "work dir")
 	flag.StringVar(&param.OutputDirPath, "od", "output_dir", "output dir")
+	flag.StringVar(&param.PresetDirPath, "pd", "preset_dir", "preset dir")
+	flag.StringVar(&param.BdmvPreset, "bp", "", "preset for tasks generated from bdmv folders")
+	flag.StringVar(&param.BdmvAudioLangs, "bal", "jpn", "preferred audio languages for bdmv folders (comma separated)")
+	flag.StringVar(&param.BdmvSubLangs, "bsl", "", "preferred subtitle languages for bdmv folders (comma separated)")
 	flag.StringVar(&param.Ip, "ip", "127.0.0.1", "web interface's ip")
 	flag.StringVar(&param.Port, "port", "8899", "web interface's port")
 	flag.StringVar(&param.ActiveTime, "at", "", "active time (HH:MM:SS-HH:MM:SS)")
+	flag.StringVar(&param.NotifyUrl, "nu", "", "webhook url for completion notifications")
 	flag.Parse()
 
 	err := common.CheckToolsAvailability()
@@ -60,18 +68,30 @@ func main() {
 	}
 
 	var wg sync.WaitGroup
-	workerSequence := []worker.Worker{
-		monitor.NewMonitor(&wg, &param, 0),
-		video.NewMultiWorker(&wg, &param, 0),
-		misc.NewMiscWorker(&wg, &param, 0),
-		mux.NewMuxWorker(&wg, &param, 0),
-		final.NewFinalWorker(&wg, &param, 0),
-	}
+	monitorWorker := monitor.NewMonitor(&wg, &param, 0)
+	forkWorker := branch.NewForkWorker(&wg, &param, 0)
+	videoWorker := video.NewMultiWorker(&wg, &param, 0)
+	miscWorker := misc.NewMiscWorker(&wg, &param, 0)
+	joinWorker := branch.NewJoinWorker(&wg, 0)
+	muxWorker := mux.NewMuxWorker(&wg, &param, 0)
+	finalWorker := final.NewFinalWorker(&wg, &param, 0)
+
+	forkWorker.SetInputStream(monitorWorker.GetOutputStream())
+	videoWorker.SetInputStream(forkWorker.GetOutputStream())
+	miscWorker.SetInputStream(forkWorker.GetMiscStream())
+	joinWorker.SetInputStream(videoWorker.GetOutputStream())
+	joinWorker.SetMiscStream(miscWorker.GetOutputStream())
+	muxWorker.SetInputStream(joinWorker.GetOutputStream())
+	finalWorker.SetInputStream(muxWorker.GetOutputStream())
 
-	var upperStream chan common.Task
-	for _, w := range workerSequence {
-		w.SetInputStream(upperStream)
-		upperStream = w.GetOutputStream()
+	workerSequence := []worker.Worker{
+		monitorWorker,
+		forkWorker,
+		videoWorker,
+		miscWorker,
+		joinWorker,
+		muxWorker,
+		finalWorker,
 	}
 
 	mainCtx, mainCtxCancelFunc := context.WithCancel(context.Background())
@@ -86,6 +106,8 @@ func main() {
 		}
 	}
 
+	notify.SetWebhook(param.NotifyUrl)
+
 	if param.ActiveTime != "" {
 		err = activetime.SetActiveTime(param.ActiveTime)
 		if err != nil {
@@ -0,0 +1,61 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package bdmv
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+func ReadClipStartTime(clpiPath string, stcId byte) (uint32, error) {
+	data, err := ioutil.ReadFile(clpiPath)
+	if err != nil {
+		return 0, errors.New("failed to read clpi file: " + err.Error())
+	}
+
+	if len(data) < 12 || string(data[0:4]) != "HDMV" {
+		return 0, errors.New("not a clpi file: " + clpiPath)
+	}
+
+	r := reader{data: data}
+	r.seek(8)
+	sequenceAddr := r.u32()
+	r.seek(int(sequenceAddr))
+	r.skip(4 + 1)
+	atcNum := int(r.u8())
+	for i := 0; i < atcNum && r.err == nil; i++ {
+		r.skip(4)
+		stcNum := int(r.u8())
+		stcOffset := int(r.u8())
+		for j := 0; j < stcNum && r.err == nil; j++ {
+			r.skip(2 + 4)
+			startTime := r.u32()
+			r.skip(4)
+			if stcOffset+j == int(stcId) && r.err == nil {
+				return startTime, nil
+			}
+		}
+	}
+
+	if r.err != nil {
+		return 0, fmt.Errorf("failed to parse clpi file %s: %s", clpiPath, r.err.Error())
+	}
+	return 0, fmt.Errorf("stc sequence %d not found in %s", stcId, clpiPath)
+}
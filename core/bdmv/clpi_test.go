@@ -0,0 +1,100 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package bdmv
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func buildClpi(atcList [][]uint32) []byte {
+	var sequence bytes.Buffer
+	writeBE(&sequence, byte(0), byte(len(atcList)))
+	for _, startTimeList := range atcList {
+		writeBE(&sequence, uint32(0), byte(len(startTimeList)), byte(0))
+		for _, startTime := range startTimeList {
+			writeBE(&sequence, uint16(0x1001), uint32(0), startTime, startTime+45000*3600)
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("HDMV0200")
+	writeBE(&buf, uint32(40))
+	buf.Write(make([]byte, 40-buf.Len()))
+	writeBE(&buf, uint32(sequence.Len()))
+	buf.Write(sequence.Bytes())
+	return buf.Bytes()
+}
+
+func TestReadClipStartTime(t *testing.T) {
+	testList := []struct {
+		name    string
+		atcList [][]uint32
+		stcId   byte
+		expect  uint32
+		isError bool
+	}{
+		{name: "single stc", atcList: [][]uint32{{27000000}}, stcId: 0, expect: 27000000},
+		{name: "second stc", atcList: [][]uint32{{27000000, 5400000}}, stcId: 1, expect: 5400000},
+		{name: "stc of the second atc", atcList: [][]uint32{{100}, {200}}, stcId: 0, expect: 100},
+		{name: "missing stc", atcList: [][]uint32{{27000000}}, stcId: 2, isError: true},
+	}
+
+	for _, test := range testList {
+		clpiPath := filepath.Join(t.TempDir(), "00001.clpi")
+		writeTestFile(t, clpiPath, buildClpi(test.atcList))
+
+		startTime, err := ReadClipStartTime(clpiPath, test.stcId)
+		if test.isError {
+			if err == nil {
+				t.Errorf("%s: expect an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+			continue
+		}
+		if startTime != test.expect {
+			t.Errorf("%s: got %d, expect %d", test.name, startTime, test.expect)
+		}
+	}
+}
+
+func TestReadClipStartTimeInvalid(t *testing.T) {
+	valid := buildClpi([][]uint32{{27000000}})
+
+	testList := []struct {
+		name string
+		data []byte
+	}{
+		{name: "wrong magic", data: append([]byte("MPLS"), valid[4:]...)},
+		{name: "truncated", data: valid[:len(valid)-6]},
+	}
+
+	for _, test := range testList {
+		clpiPath := filepath.Join(t.TempDir(), "00001.clpi")
+		writeTestFile(t, clpiPath, test.data)
+		_, err := ReadClipStartTime(clpiPath, 0)
+		if err == nil {
+			t.Errorf("%s: expect an error", test.name)
+		}
+	}
+}
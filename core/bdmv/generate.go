@@ -0,0 +1,350 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package bdmv
+
+import (
+	"MonitorEncoder/core/common"
+	"MonitorEncoder/core/probe"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const DefaultMinDuration = 10 * time.Minute
+
+type Request struct {
+	Path              string   `json:"path" yaml:"path" toml:"path"`
+	Preset            string   `json:"preset" yaml:"preset" toml:"preset"`
+	AudioLanguages    []string `json:"audio_languages" yaml:"audio_languages" toml:"audio_languages"`
+	SubtitleLanguages []string `json:"subtitle_languages" yaml:"subtitle_languages" toml:"subtitle_languages"`
+	MinDuration       uint     `json:"min_duration" yaml:"min_duration" toml:"min_duration"`
+	Batch             string   `json:"batch" yaml:"batch" toml:"batch"`
+}
+
+func IsMarkerFile(path string) bool {
+	name := filepath.Base(path)
+	return common.IsTaskFile(name) && strings.HasSuffix(strings.TrimSuffix(name, filepath.Ext(name)), ".bdmv")
+}
+
+func NewRequestFromFile(markerPath string) (*Request, error) {
+	var req Request
+	err := common.DecodeTaskFile(markerPath, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Path == "" {
+		return nil, errors.New("bdmv marker has no path")
+	}
+
+	return &req, nil
+}
+
+func FindBdmvPath(path string) (string, bool) {
+	candidateList := []string{path, filepath.Join(path, "BDMV")}
+	for _, candidate := range candidateList {
+		playlistInfo, err := os.Stat(filepath.Join(candidate, "PLAYLIST"))
+		if err != nil || !playlistInfo.IsDir() {
+			continue
+		}
+		streamInfo, err := os.Stat(filepath.Join(candidate, "STREAM"))
+		if err != nil || !streamInfo.IsDir() {
+			continue
+		}
+		return candidate, true
+	}
+	return "", false
+}
+
+func ScanPlaylists(bdmvPath string) ([]*Playlist, error) {
+	playlistDirPath := filepath.Join(bdmvPath, "PLAYLIST")
+	fileInfoList, err := ioutil.ReadDir(playlistDirPath)
+	if err != nil {
+		return nil, errors.New("failed to read playlist dir: " + err.Error())
+	}
+
+	playlistList := make([]*Playlist, 0)
+	for _, fileInfo := range fileInfoList {
+		if fileInfo.IsDir() || strings.ToLower(filepath.Ext(fileInfo.Name())) != ".mpls" {
+			continue
+		}
+
+		playlist, err := ParsePlaylist(filepath.Join(playlistDirPath, fileInfo.Name()))
+		if err != nil {
+			log.Printf("[warning] skip playlist %s: %s\n", fileInfo.Name(), err.Error())
+			continue
+		}
+		playlistList = append(playlistList, playlist)
+	}
+
+	return playlistList, nil
+}
+
+func FindTitles(playlistList []*Playlist, minDuration time.Duration) []*Playlist {
+	candidateList := make([]*Playlist, 0)
+	clipSeqSet := make(map[string]bool)
+
+	sort.Slice(playlistList, func(i int, j int) bool {
+		return playlistList[i].Name < playlistList[j].Name
+	})
+
+	for _, playlist := range playlistList {
+		if playlist.Duration() < minDuration {
+			continue
+		}
+
+		clipList := playlist.ClipList()
+		if hasRepeatedClip(clipList) {
+			continue
+		}
+
+		clipSeq := strings.Join(clipList, ",")
+		if clipSeqSet[clipSeq] {
+			continue
+		}
+		clipSeqSet[clipSeq] = true
+
+		candidateList = append(candidateList, playlist)
+	}
+
+	titleList := make([]*Playlist, 0)
+	for _, playlist := range candidateList {
+		if !isPlayAll(playlist, candidateList) {
+			titleList = append(titleList, playlist)
+		}
+	}
+
+	return titleList
+}
+
+func hasRepeatedClip(clipList []string) bool {
+	clipSet := make(map[string]bool)
+	for _, clip := range clipList {
+		if clipSet[clip] {
+			return true
+		}
+		clipSet[clip] = true
+	}
+	return false
+}
+
+func isPlayAll(playlist *Playlist, candidateList []*Playlist) bool {
+	clipSet := make(map[string]bool)
+	for _, clip := range playlist.ClipList() {
+		clipSet[clip] = true
+	}
+
+	containNum := 0
+	for _, other := range candidateList {
+		if other == playlist || len(other.ItemList) >= len(playlist.ItemList) {
+			continue
+		}
+
+		contained := true
+		for _, clip := range other.ClipList() {
+			if !clipSet[clip] {
+				contained = false
+				break
+			}
+		}
+		if contained {
+			containNum += 1
+		}
+	}
+
+	return containNum >= 2
+}
+
+func GenerateTasks(ctx context.Context, req *Request, presetDirPath string) ([]*common.Task, error) {
+	bdmvPath, found := FindBdmvPath(req.Path)
+	if !found {
+		return nil, errors.New("no BDMV structure found in " + req.Path)
+	}
+
+	playlistList, err := ScanPlaylists(bdmvPath)
+	if err != nil {
+		return nil, err
+	}
+
+	minDuration := DefaultMinDuration
+	if req.MinDuration > 0 {
+		minDuration = time.Duration(req.MinDuration) * time.Second
+	}
+
+	titleList := FindTitles(playlistList, minDuration)
+	if len(titleList) <= 0 {
+		return nil, errors.New("no main title found in " + bdmvPath)
+	}
+
+	batch := req.Batch
+	if batch == "" {
+		batch = filepath.Base(filepath.Clean(req.Path))
+	}
+
+	taskList := make([]*common.Task, 0, len(titleList))
+	errDescList := make([]string, 0)
+	for _, playlist := range titleList {
+		task, err := generateTask(ctx, req, presetDirPath, playlist)
+		if err != nil {
+			log.Printf("[warning] skip playlist %s: %s\n", playlist.Name, err.Error())
+			errDescList = append(errDescList, fmt.Sprintf("playlist %s: %s", playlist.Name, err.Error()))
+			continue
+		}
+		task.Batch = batch
+
+		taskList = append(taskList, task)
+	}
+
+	if len(taskList) <= 0 {
+		return nil, errors.New(strings.Join(errDescList, "; "))
+	}
+
+	return taskList, nil
+}
+
+func generateTask(ctx context.Context, req *Request, presetDirPath string, playlist *Playlist) (*common.Task, error) {
+	task := common.Task{
+		Src:    playlist.Path,
+		Preset: req.Preset,
+	}
+
+	sourceInfo, err := probe.Probe(ctx, playlist.Path)
+	if err != nil {
+		return nil, err
+	}
+	task.SourceInfo = sourceInfo
+
+	trackMap, err := streamTrackMap(playlist, sourceInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	err = common.ApplyPreset(&task, presetDirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	audioTemplateList := task.Audio
+	task.Audio = make([]common.AudioTask, 0)
+	for _, lang := range req.AudioLanguages {
+		track, stream, found := findStream(playlist, StreamAudio, lang, trackMap)
+		if !found {
+			log.Printf("[warning] playlist %s has no %s audio track\n", playlist.Name, lang)
+			continue
+		}
+
+		audioTask := common.AudioTask{Codec: "flac"}
+		for _, audioTemplate := range audioTemplateList {
+			if audioTemplate.Language == lang {
+				audioTask = audioTemplate
+				break
+			}
+		}
+		if audioTask.Language != lang && len(audioTemplateList) > 0 {
+			audioTask = audioTemplateList[0]
+		}
+		audioTask.Track = track
+		audioTask.Language = stream.Language
+
+		task.Audio = append(task.Audio, audioTask)
+	}
+
+	demuxTemplateList := task.Demux
+	task.Demux = make([]common.DemuxTask, 0)
+	for _, lang := range req.SubtitleLanguages {
+		track, stream, found := findStream(playlist, StreamSubtitle, lang, trackMap)
+		if !found {
+			log.Printf("[warning] playlist %s has no %s subtitle track\n", playlist.Name, lang)
+			continue
+		}
+
+		demuxTask := common.DemuxTask{Format: "sup"}
+		if len(demuxTemplateList) > 0 {
+			demuxTask = demuxTemplateList[0]
+		}
+		demuxTask.Track = track
+		demuxTask.Language = stream.Language
+
+		task.Demux = append(task.Demux, demuxTask)
+	}
+
+	return &task, nil
+}
+
+var streamTrackTypeMap = map[StreamKind]common.TrackType{
+	StreamAudio:    common.TrackAudio,
+	StreamSubtitle: common.TrackSubtitle,
+}
+
+func streamTrackMap(playlist *Playlist, sourceInfo *common.SourceInfo) (map[StreamKind][]uint, error) {
+	trackMap := make(map[StreamKind][]uint)
+	for kind, trackType := range streamTrackTypeMap {
+		trackInfoList := make([]common.TrackInfo, 0)
+		for _, trackInfo := range sourceInfo.TrackList {
+			if trackInfo.Type == trackType {
+				trackInfoList = append(trackInfoList, trackInfo)
+			}
+		}
+
+		streamList := playlist.Streams(kind)
+		trackList := make([]uint, 0, len(streamList))
+		if len(streamList) == len(trackInfoList) {
+			for _, trackInfo := range trackInfoList {
+				trackList = append(trackList, trackInfo.Track)
+			}
+			trackMap[kind] = trackList
+			continue
+		}
+
+		next := 0
+		for i, stream := range streamList {
+			for next < len(trackInfoList) && !isSameLanguage(stream.Language, trackInfoList[next].Language) {
+				next += 1
+			}
+			if next >= len(trackInfoList) {
+				return nil, fmt.Errorf("no %s track listed by eac3to matches stream #%d (%s, pid 0x%04x) of the playlist", trackType, i+1, stream.Language, stream.Pid)
+			}
+			trackList = append(trackList, trackInfoList[next].Track)
+			next += 1
+		}
+		trackMap[kind] = trackList
+	}
+
+	return trackMap, nil
+}
+
+func isSameLanguage(streamLang string, trackLang string) bool {
+	return streamLang == "" || trackLang == "" || strings.EqualFold(streamLang, trackLang)
+}
+
+func findStream(playlist *Playlist, kind StreamKind, lang string, trackMap map[StreamKind][]uint) (uint, Stream, bool) {
+	for i, stream := range playlist.Streams(kind) {
+		if stream.Language == lang {
+			return trackMap[kind][i], stream, true
+		}
+	}
+	return 0, Stream{}, false
+}
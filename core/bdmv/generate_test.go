@@ -0,0 +1,182 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package bdmv
+
+import (
+	"MonitorEncoder/core/common"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestPlaylist(name string, clipList []string, minutes uint32) *Playlist {
+	playlist := Playlist{Name: name}
+	for _, clip := range clipList {
+		playlist.ItemList = append(playlist.ItemList, PlayItem{Clip: clip, OutTime: minutes * 60 * 45000 / uint32(len(clipList))})
+	}
+	return &playlist
+}
+
+func TestFindTitles(t *testing.T) {
+	testList := []struct {
+		name         string
+		playlistList []*Playlist
+		expect       []string
+	}{
+		{
+			name: "short playlists are skipped",
+			playlistList: []*Playlist{
+				newTestPlaylist("00001", []string{"00001"}, 24),
+				newTestPlaylist("00002", []string{"00002"}, 2),
+			},
+			expect: []string{"00001"},
+		},
+		{
+			name: "duplicated and looping playlists are skipped",
+			playlistList: []*Playlist{
+				newTestPlaylist("00003", []string{"00001", "00002"}, 48),
+				newTestPlaylist("00001", []string{"00001", "00002"}, 48),
+				newTestPlaylist("00002", []string{"00003", "00003"}, 48),
+			},
+			expect: []string{"00001"},
+		},
+		{
+			name: "play all playlists are skipped",
+			playlistList: []*Playlist{
+				newTestPlaylist("00000", []string{"00001", "00002", "00003"}, 72),
+				newTestPlaylist("00001", []string{"00001"}, 24),
+				newTestPlaylist("00002", []string{"00002"}, 24),
+				newTestPlaylist("00003", []string{"00003"}, 24),
+			},
+			expect: []string{"00001", "00002", "00003"},
+		},
+		{
+			name: "a multi-clip title containing one other title is kept",
+			playlistList: []*Playlist{
+				newTestPlaylist("00000", []string{"00001", "00002"}, 48),
+				newTestPlaylist("00001", []string{"00001"}, 24),
+			},
+			expect: []string{"00000", "00001"},
+		},
+	}
+
+	for _, test := range testList {
+		nameList := make([]string, 0)
+		for _, playlist := range FindTitles(test.playlistList, DefaultMinDuration) {
+			nameList = append(nameList, playlist.Name)
+		}
+		if !reflect.DeepEqual(nameList, test.expect) {
+			t.Errorf("%s: got %v, expect %v", test.name, nameList, test.expect)
+		}
+	}
+}
+
+func TestStreamTrackMap(t *testing.T) {
+	playlist := &Playlist{ItemList: []PlayItem{{StreamList: []Stream{
+		{Kind: StreamVideo},
+		{Kind: StreamAudio, Language: "jpn"},
+		{Kind: StreamAudio, Language: "eng"},
+		{Kind: StreamSubtitle, Language: "jpn"},
+		{Kind: StreamOther, Language: "jpn"},
+	}}}}
+
+	testList := []struct {
+		name      string
+		trackList []common.TrackInfo
+		expect    map[StreamKind][]uint
+		isError   bool
+	}{
+		{
+			name: "same count",
+			trackList: []common.TrackInfo{
+				{Track: 2, Type: common.TrackVideo},
+				{Track: 3, Type: common.TrackAudio, Language: "jpn"},
+				{Track: 4, Type: common.TrackAudio, Language: "eng"},
+				{Track: 5, Type: common.TrackSubtitle, Language: "jpn"},
+			},
+			expect: map[StreamKind][]uint{StreamAudio: {3, 4}, StreamSubtitle: {5}},
+		},
+		{
+			name: "secondary audio is matched by language",
+			trackList: []common.TrackInfo{
+				{Track: 2, Type: common.TrackVideo},
+				{Track: 3, Type: common.TrackAudio, Language: "jpn"},
+				{Track: 4, Type: common.TrackAudio, Language: "jpn"},
+				{Track: 5, Type: common.TrackAudio, Language: "eng"},
+				{Track: 6, Type: common.TrackSubtitle, Language: "jpn"},
+			},
+			expect: map[StreamKind][]uint{StreamAudio: {3, 5}, StreamSubtitle: {6}},
+		},
+		{
+			name: "extra track before the match",
+			trackList: []common.TrackInfo{
+				{Track: 3, Type: common.TrackAudio, Language: "fre"},
+				{Track: 4, Type: common.TrackAudio, Language: "jpn"},
+				{Track: 5, Type: common.TrackAudio, Language: "eng"},
+				{Track: 6, Type: common.TrackSubtitle, Language: "jpn"},
+			},
+			expect: map[StreamKind][]uint{StreamAudio: {4, 5}, StreamSubtitle: {6}},
+		},
+		{
+			name: "missing track",
+			trackList: []common.TrackInfo{
+				{Track: 3, Type: common.TrackAudio, Language: "jpn"},
+				{Track: 4, Type: common.TrackSubtitle, Language: "jpn"},
+			},
+			isError: true,
+		},
+		{
+			name: "language mismatch",
+			trackList: []common.TrackInfo{
+				{Track: 3, Type: common.TrackAudio, Language: "jpn"},
+				{Track: 4, Type: common.TrackAudio, Language: "ger"},
+				{Track: 5, Type: common.TrackAudio, Language: "fre"},
+				{Track: 6, Type: common.TrackSubtitle, Language: "jpn"},
+			},
+			isError: true,
+		},
+	}
+
+	for _, test := range testList {
+		trackMap, err := streamTrackMap(playlist, &common.SourceInfo{TrackList: test.trackList})
+		if test.isError {
+			if err == nil {
+				t.Errorf("%s: expect an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(trackMap, test.expect) {
+			t.Errorf("%s: got %v, expect %v", test.name, trackMap, test.expect)
+		}
+	}
+}
+
+func TestFindTitlesMinDuration(t *testing.T) {
+	playlistList := []*Playlist{newTestPlaylist("00001", []string{"00001"}, 5)}
+	if len(FindTitles(playlistList, DefaultMinDuration)) != 0 {
+		t.Errorf("expect no title with the default minimum duration")
+	}
+	if len(FindTitles(playlistList, 4*time.Minute)) != 1 {
+		t.Errorf("expect one title with a 4 minute minimum duration")
+	}
+}
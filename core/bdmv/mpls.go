@@ -0,0 +1,431 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package bdmv
+
+import (
+	"MonitorEncoder/core/chapter"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const clockRate = 45000
+
+const (
+	MarkEntry = 1
+	MarkLink  = 2
+)
+
+type StreamKind int
+
+const (
+	StreamVideo StreamKind = iota
+	StreamAudio
+	StreamSubtitle
+	StreamOther
+)
+
+type Stream struct {
+	Kind       StreamKind
+	CodingType byte
+	FrameRate  byte
+	Pid        uint16
+	Language   string
+}
+
+type PlayItem struct {
+	Clip       string
+	StcId      byte
+	InTime     uint32
+	OutTime    uint32
+	StreamList []Stream
+}
+
+type ClipRange struct {
+	Path  string
+	Start int
+	End   int
+}
+
+type Mark struct {
+	Type     byte
+	PlayItem uint16
+	Time     uint32
+}
+
+type Playlist struct {
+	Name     string
+	Path     string
+	ItemList []PlayItem
+	MarkList []Mark
+}
+
+var frameRateMap = map[byte][2]uint{
+	1: {24000, 1001},
+	2: {24, 1},
+	3: {25, 1},
+	4: {30000, 1001},
+	6: {50, 1},
+	7: {60000, 1001},
+}
+
+var codingTypeNameMap = map[byte]string{
+	0x01: "mpeg1",
+	0x02: "mpeg2",
+	0x1b: "h264",
+	0x20: "mvc",
+	0x24: "hevc",
+	0xea: "vc1",
+	0x03: "mp2",
+	0x04: "mp2",
+	0x80: "pcm",
+	0x81: "ac3",
+	0x82: "dts",
+	0x83: "truehd",
+	0x84: "eac3",
+	0x85: "dtshr",
+	0x86: "dtsma",
+	0xa1: "eac3",
+	0xa2: "dts",
+	0x90: "pgs",
+	0x91: "igs",
+	0x92: "text",
+}
+
+func CodingTypeName(codingType byte) string {
+	name, exist := codingTypeNameMap[codingType]
+	if !exist {
+		return fmt.Sprintf("unknown(0x%02x)", codingType)
+	}
+	return name
+}
+
+func ParsePlaylist(mplsPath string) (*Playlist, error) {
+	data, err := ioutil.ReadFile(mplsPath)
+	if err != nil {
+		return nil, errors.New("failed to read mpls file: " + err.Error())
+	}
+
+	if len(data) < 20 || string(data[0:4]) != "MPLS" {
+		return nil, errors.New("not a mpls file: " + mplsPath)
+	}
+
+	playlist := Playlist{
+		Name:     strings.TrimSuffix(filepath.Base(mplsPath), filepath.Ext(mplsPath)),
+		Path:     mplsPath,
+		ItemList: make([]PlayItem, 0),
+		MarkList: make([]Mark, 0),
+	}
+
+	r := reader{data: data}
+	r.seek(8)
+	playlistAddr := r.u32()
+	markAddr := r.u32()
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	r.seek(int(playlistAddr))
+	r.skip(4 + 2)
+	itemNum := int(r.u16())
+	r.skip(2)
+	for i := 0; i < itemNum && r.err == nil; i++ {
+		item, err := parsePlayItem(&r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse play item #%d: %s", i, err.Error())
+		}
+		playlist.ItemList = append(playlist.ItemList, item)
+	}
+
+	r.seek(int(markAddr))
+	r.skip(4)
+	markNum := int(r.u16())
+	for i := 0; i < markNum && r.err == nil; i++ {
+		r.skip(1)
+		mark := Mark{
+			Type:     r.u8(),
+			PlayItem: r.u16(),
+			Time:     r.u32(),
+		}
+		r.skip(2 + 4)
+		playlist.MarkList = append(playlist.MarkList, mark)
+	}
+
+	if r.err != nil {
+		return nil, fmt.Errorf("failed to parse mpls file %s: %s", mplsPath, r.err.Error())
+	}
+
+	return &playlist, nil
+}
+
+func parsePlayItem(r *reader) (PlayItem, error) {
+	length := int(r.u16())
+	itemEnd := r.pos + length
+
+	item := PlayItem{
+		Clip:       string(r.bytes(5)),
+		StreamList: make([]Stream, 0),
+	}
+	r.skip(4)
+	isMultiAngle := r.u16()&0x0010 != 0
+	item.StcId = r.u8()
+	item.InTime = r.u32()
+	item.OutTime = r.u32()
+	r.skip(8 + 1 + 1 + 2)
+
+	if isMultiAngle {
+		angleNum := int(r.u8())
+		r.skip(1)
+		r.skip((angleNum - 1) * 10)
+	}
+
+	r.skip(2 + 2)
+	streamNumList := make([]int, 7)
+	for i := range streamNumList {
+		streamNumList[i] = int(r.u8())
+	}
+	r.skip(5)
+
+	kindList := []StreamKind{StreamVideo, StreamAudio, StreamSubtitle, StreamOther, StreamOther, StreamOther, StreamOther}
+	for i, streamNum := range streamNumList {
+		for j := 0; j < streamNum && r.err == nil; j++ {
+			stream := Stream{Kind: kindList[i]}
+
+			entryLength := int(r.u8())
+			entryEnd := r.pos + entryLength
+			streamType := r.u8()
+			switch streamType {
+			case 1:
+				stream.Pid = r.u16()
+			case 2, 4:
+				r.skip(2)
+				stream.Pid = r.u16()
+			case 3:
+				r.skip(1)
+				stream.Pid = r.u16()
+			}
+			r.seek(entryEnd)
+
+			attrLength := int(r.u8())
+			attrEnd := r.pos + attrLength
+			stream.CodingType = r.u8()
+			switch stream.CodingType {
+			case 0x01, 0x02, 0x1b, 0x20, 0x24, 0xea:
+				stream.FrameRate = r.u8() & 0x0f
+			case 0x03, 0x04, 0x80, 0x81, 0x82, 0x83, 0x84, 0x85, 0x86, 0xa1, 0xa2:
+				r.skip(1)
+				stream.Language = string(r.bytes(3))
+			case 0x90, 0x91:
+				stream.Language = string(r.bytes(3))
+			case 0x92:
+				r.skip(1)
+				stream.Language = string(r.bytes(3))
+			}
+			r.seek(attrEnd)
+
+			item.StreamList = append(item.StreamList, stream)
+		}
+	}
+
+	r.seek(itemEnd)
+
+	return item, r.err
+}
+
+func (p *Playlist) Duration() time.Duration {
+	var ticks uint64
+	for _, item := range p.ItemList {
+		if item.OutTime > item.InTime {
+			ticks += uint64(item.OutTime - item.InTime)
+		}
+	}
+	return TicksToDuration(ticks)
+}
+
+func (p *Playlist) ClipList() []string {
+	clipList := make([]string, 0, len(p.ItemList))
+	for _, item := range p.ItemList {
+		clipList = append(clipList, item.Clip)
+	}
+	return clipList
+}
+
+func (p *Playlist) Streams(kind StreamKind) []Stream {
+	streamList := make([]Stream, 0)
+	if len(p.ItemList) <= 0 {
+		return streamList
+	}
+
+	for _, stream := range p.ItemList[0].StreamList {
+		if stream.Kind == kind {
+			streamList = append(streamList, stream)
+		}
+	}
+	return streamList
+}
+
+func TicksToDuration(ticks uint64) time.Duration {
+	return time.Duration(ticks * uint64(time.Second) / clockRate)
+}
+
+type reader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *reader) seek(pos int) {
+	if r.err == nil && (pos < 0 || pos > len(r.data)) {
+		r.err = errors.New("unexpected end of data")
+	}
+	r.pos = pos
+}
+
+func (r *reader) skip(n int) {
+	r.seek(r.pos + n)
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil || r.pos+n > len(r.data) {
+		if r.err == nil {
+			r.err = errors.New("unexpected end of data")
+		}
+		return make([]byte, n)
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *reader) u8() byte {
+	return r.bytes(1)[0]
+}
+
+func (r *reader) u16() uint16 {
+	return binary.BigEndian.Uint16(r.bytes(2))
+}
+
+func (r *reader) u32() uint32 {
+	return binary.BigEndian.Uint32(r.bytes(4))
+}
+
+func IsPlaylist(path string) bool {
+	return strings.ToLower(filepath.Ext(path)) == ".mpls"
+}
+
+func (p *Playlist) BdmvPath() string {
+	return filepath.Dir(filepath.Dir(p.Path))
+}
+
+func (p *Playlist) ClipPathList() []string {
+	clipPathList := make([]string, 0, len(p.ItemList))
+	for _, item := range p.ItemList {
+		clipPathList = append(clipPathList, filepath.Join(p.BdmvPath(), "STREAM", item.Clip+".m2ts"))
+	}
+	return clipPathList
+}
+
+func (p *Playlist) FPS() (uint, uint) {
+	for _, stream := range p.Streams(StreamVideo) {
+		if fps, exist := frameRateMap[stream.FrameRate]; exist {
+			return fps[0], fps[1]
+		}
+	}
+	return 0, 0
+}
+
+func (p *Playlist) ClipRangeList() ([]ClipRange, error) {
+	fpsNum, fpsDen := p.FPS()
+	if fpsNum == 0 || fpsDen == 0 {
+		return nil, errors.New("unknown frame rate of playlist " + p.Path)
+	}
+
+	ticksToFrame := func(ticks int64) int {
+		if ticks < 0 {
+			return 0
+		}
+		return int((ticks*int64(fpsNum)*2 + int64(fpsDen)*clockRate) / (int64(fpsDen) * clockRate * 2))
+	}
+
+	clipPathList := p.ClipPathList()
+	clipRangeList := make([]ClipRange, 0, len(p.ItemList))
+	for i, item := range p.ItemList {
+		clpiPath := filepath.Join(p.BdmvPath(), "CLIPINF", item.Clip+".clpi")
+		startTime, err := ReadClipStartTime(clpiPath, item.StcId)
+		if err != nil {
+			return nil, err
+		}
+
+		clipRange := ClipRange{
+			Path:  clipPathList[i],
+			Start: ticksToFrame(int64(item.InTime) - int64(startTime)),
+			End:   ticksToFrame(int64(item.OutTime) - int64(startTime)),
+		}
+		if clipRange.End <= clipRange.Start {
+			return nil, fmt.Errorf("empty play item #%d in playlist %s", i, p.Path)
+		}
+		clipRangeList = append(clipRangeList, clipRange)
+	}
+
+	return clipRangeList, nil
+}
+
+func (p *Playlist) Chapters() []chapter.Chapter {
+	itemOffsetList := make([]uint64, len(p.ItemList))
+	var offset uint64
+	for i, item := range p.ItemList {
+		itemOffsetList[i] = offset
+		if item.OutTime > item.InTime {
+			offset += uint64(item.OutTime - item.InTime)
+		}
+	}
+
+	chapterList := make([]chapter.Chapter, 0)
+	var lastTicks uint64
+	for _, mark := range p.MarkList {
+		if mark.Type != MarkEntry || int(mark.PlayItem) >= len(p.ItemList) {
+			continue
+		}
+
+		item := p.ItemList[mark.PlayItem]
+		if mark.Time < item.InTime || mark.Time >= item.OutTime {
+			continue
+		}
+
+		ticks := itemOffsetList[mark.PlayItem] + uint64(mark.Time-item.InTime)
+		if len(chapterList) > 0 && ticks <= lastTicks {
+			continue
+		}
+		if offset-ticks < clockRate {
+			continue
+		}
+		lastTicks = ticks
+
+		chapterList = append(chapterList, chapter.Chapter{
+			Start: TicksToDuration(ticks),
+			Name:  chapter.DefaultName(len(chapterList)),
+		})
+	}
+
+	return chapterList
+}
@@ -0,0 +1,314 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package bdmv
+
+import (
+	"MonitorEncoder/core/chapter"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testStream struct {
+	kindIndex  int
+	pid        uint16
+	codingType byte
+	attr       byte
+	lang       string
+}
+
+type testItem struct {
+	clip       string
+	stcId      byte
+	inTime     uint32
+	outTime    uint32
+	streamList []testStream
+}
+
+func writeBE(buf *bytes.Buffer, valueList ...interface{}) {
+	for _, value := range valueList {
+		_ = binary.Write(buf, binary.BigEndian, value)
+	}
+}
+
+func buildPlayItem(item testItem) []byte {
+	var body bytes.Buffer
+	body.WriteString(item.clip)
+	body.WriteString("M2TS")
+	writeBE(&body, uint16(0), item.stcId, item.inTime, item.outTime)
+	body.Write(make([]byte, 8+1+1+2))
+
+	streamNumList := make([]byte, 7)
+	for _, stream := range item.streamList {
+		streamNumList[stream.kindIndex] += 1
+	}
+	var stn bytes.Buffer
+	writeBE(&stn, uint16(0))
+	stn.Write(streamNumList)
+	stn.Write(make([]byte, 5))
+	for kindIndex := range streamNumList {
+		for _, stream := range item.streamList {
+			if stream.kindIndex != kindIndex {
+				continue
+			}
+			writeBE(&stn, byte(9), byte(1), stream.pid)
+			stn.Write(make([]byte, 6))
+
+			attr := []byte{stream.codingType}
+			if stream.lang == "" {
+				attr = append(attr, stream.attr)
+			} else if stream.codingType == 0x90 || stream.codingType == 0x91 {
+				attr = append(attr, []byte(stream.lang)...)
+			} else {
+				attr = append(attr, stream.attr)
+				attr = append(attr, []byte(stream.lang)...)
+			}
+			writeBE(&stn, byte(5))
+			stn.Write(append(attr, make([]byte, 5-len(attr))...))
+		}
+	}
+	writeBE(&body, uint16(stn.Len()))
+	body.Write(stn.Bytes())
+
+	var buf bytes.Buffer
+	writeBE(&buf, uint16(body.Len()))
+	buf.Write(body.Bytes())
+	return buf.Bytes()
+}
+
+func buildMpls(itemList []testItem, markList []Mark) []byte {
+	var playlist bytes.Buffer
+	for _, item := range itemList {
+		playlist.Write(buildPlayItem(item))
+	}
+
+	var marks bytes.Buffer
+	for _, mark := range markList {
+		writeBE(&marks, byte(0), mark.Type, mark.PlayItem, mark.Time, uint16(0xffff), uint32(0))
+	}
+
+	playlistAddr := uint32(40)
+	markAddr := playlistAddr + 4 + 6 + uint32(playlist.Len())
+
+	var buf bytes.Buffer
+	buf.WriteString("MPLS0200")
+	writeBE(&buf, playlistAddr, markAddr)
+	buf.Write(make([]byte, int(playlistAddr)-buf.Len()))
+	writeBE(&buf, uint32(6+playlist.Len()), uint16(0), uint16(len(itemList)), uint16(0))
+	buf.Write(playlist.Bytes())
+	writeBE(&buf, uint32(2+marks.Len()), uint16(len(markList)))
+	buf.Write(marks.Bytes())
+	return buf.Bytes()
+}
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	err := os.MkdirAll(filepath.Dir(path), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+var testStreamList = []testStream{
+	{kindIndex: 0, pid: 0x1011, codingType: 0x24, attr: 0x61},
+	{kindIndex: 1, pid: 0x1100, codingType: 0x83, attr: 0x31, lang: "jpn"},
+	{kindIndex: 1, pid: 0x1101, codingType: 0x81, attr: 0x61, lang: "eng"},
+	{kindIndex: 2, pid: 0x1200, codingType: 0x90, lang: "chi"},
+	{kindIndex: 4, pid: 0x1a00, codingType: 0xa1, attr: 0x61, lang: "jpn"},
+}
+
+func TestParsePlaylist(t *testing.T) {
+	itemList := []testItem{
+		{clip: "00001", stcId: 0, inTime: 27000000, outTime: 27000000 + 45000*600, streamList: testStreamList},
+		{clip: "00002", stcId: 1, inTime: 90000, outTime: 90000 + 45000*300, streamList: testStreamList},
+	}
+	markList := []Mark{
+		{Type: MarkEntry, PlayItem: 0, Time: 27000000},
+		{Type: MarkLink, PlayItem: 0, Time: 27000000 + 45000*10},
+		{Type: MarkEntry, PlayItem: 1, Time: 90000 + 45000*60},
+	}
+
+	mplsPath := filepath.Join(t.TempDir(), "BDMV", "PLAYLIST", "00005.mpls")
+	writeTestFile(t, mplsPath, buildMpls(itemList, markList))
+
+	playlist, err := ParsePlaylist(mplsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if playlist.Name != "00005" {
+		t.Errorf("name: got %s", playlist.Name)
+	}
+	if !reflect.DeepEqual(playlist.ClipList(), []string{"00001", "00002"}) {
+		t.Errorf("clips: got %v", playlist.ClipList())
+	}
+	if playlist.ItemList[1].StcId != 1 || playlist.ItemList[1].InTime != 90000 {
+		t.Errorf("second item: got stc %d in %d", playlist.ItemList[1].StcId, playlist.ItemList[1].InTime)
+	}
+	if playlist.Duration() != 15*time.Minute {
+		t.Errorf("duration: got %s", playlist.Duration())
+	}
+	if fpsNum, fpsDen := playlist.FPS(); fpsNum != 24000 || fpsDen != 1001 {
+		t.Errorf("fps: got %d/%d", fpsNum, fpsDen)
+	}
+	if !reflect.DeepEqual(playlist.MarkList, markList) {
+		t.Errorf("marks: got %v", playlist.MarkList)
+	}
+
+	streamTestList := []struct {
+		kind       StreamKind
+		pidList    []uint16
+		langList   []string
+		codingList []string
+	}{
+		{StreamVideo, []uint16{0x1011}, []string{""}, []string{"hevc"}},
+		{StreamAudio, []uint16{0x1100, 0x1101}, []string{"jpn", "eng"}, []string{"truehd", "ac3"}},
+		{StreamSubtitle, []uint16{0x1200}, []string{"chi"}, []string{"pgs"}},
+		{StreamOther, []uint16{0x1a00}, []string{"jpn"}, []string{"eac3"}},
+	}
+	for _, test := range streamTestList {
+		streamList := playlist.Streams(test.kind)
+		if len(streamList) != len(test.pidList) {
+			t.Errorf("kind %d: got %d streams, expect %d", test.kind, len(streamList), len(test.pidList))
+			continue
+		}
+		for i, stream := range streamList {
+			if stream.Pid != test.pidList[i] || stream.Language != test.langList[i] || CodingTypeName(stream.CodingType) != test.codingList[i] {
+				t.Errorf("kind %d stream #%d: got pid 0x%04x %q %s", test.kind, i, stream.Pid, stream.Language, CodingTypeName(stream.CodingType))
+			}
+		}
+	}
+}
+
+func TestParsePlaylistInvalid(t *testing.T) {
+	valid := buildMpls([]testItem{{clip: "00001", inTime: 0, outTime: 45000, streamList: testStreamList}}, nil)
+
+	testList := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: []byte{}},
+		{name: "wrong magic", data: append([]byte("HDMV"), valid[4:]...)},
+		{name: "truncated", data: valid[:len(valid)-20]},
+	}
+
+	for _, test := range testList {
+		mplsPath := filepath.Join(t.TempDir(), "00001.mpls")
+		writeTestFile(t, mplsPath, test.data)
+		_, err := ParsePlaylist(mplsPath)
+		if err == nil {
+			t.Errorf("%s: expect an error", test.name)
+		}
+	}
+}
+
+func TestPlaylistChapters(t *testing.T) {
+	itemList := []PlayItem{
+		{Clip: "00001", InTime: 45000, OutTime: 45000 + 45000*600},
+		{Clip: "00002", InTime: 0, OutTime: 45000 * 300},
+	}
+
+	testList := []struct {
+		name     string
+		markList []Mark
+		expect   []time.Duration
+	}{
+		{
+			name: "entry marks across items",
+			markList: []Mark{
+				{Type: MarkEntry, PlayItem: 0, Time: 45000},
+				{Type: MarkEntry, PlayItem: 0, Time: 45000 + 45000*120},
+				{Type: MarkEntry, PlayItem: 1, Time: 45000 * 30},
+			},
+			expect: []time.Duration{0, 2 * time.Minute, 10*time.Minute + 30*time.Second},
+		},
+		{
+			name: "link marks and marks outside the item are skipped",
+			markList: []Mark{
+				{Type: MarkEntry, PlayItem: 0, Time: 45000},
+				{Type: MarkLink, PlayItem: 0, Time: 45000 * 61},
+				{Type: MarkEntry, PlayItem: 0, Time: 0},
+				{Type: MarkEntry, PlayItem: 2, Time: 0},
+			},
+			expect: []time.Duration{0},
+		},
+		{
+			name: "duplicated and trailing marks are dropped",
+			markList: []Mark{
+				{Type: MarkEntry, PlayItem: 0, Time: 45000},
+				{Type: MarkEntry, PlayItem: 0, Time: 45000},
+				{Type: MarkEntry, PlayItem: 1, Time: 45000*300 - 100},
+			},
+			expect: []time.Duration{0},
+		},
+	}
+
+	for _, test := range testList {
+		playlist := Playlist{ItemList: itemList, MarkList: test.markList}
+		startList := make([]time.Duration, 0)
+		for _, c := range playlist.Chapters() {
+			startList = append(startList, c.Start)
+		}
+		if !reflect.DeepEqual(startList, test.expect) {
+			t.Errorf("%s: got %v, expect %v", test.name, startList, test.expect)
+		}
+	}
+
+	chapterList := (&Playlist{ItemList: itemList, MarkList: testList[0].markList}).Chapters()
+	if chapterList[1].Name != chapter.DefaultName(1) {
+		t.Errorf("chapter name: got %s", chapterList[1].Name)
+	}
+}
+
+func TestClipRangeList(t *testing.T) {
+	bdmvPath := filepath.Join(t.TempDir(), "BDMV")
+	itemList := []testItem{
+		{clip: "00001", stcId: 0, inTime: 27000000, outTime: 27000000 + 45000*10, streamList: testStreamList},
+		{clip: "00002", stcId: 1, inTime: 1000000 + 45000, outTime: 1000000 + 45000*21, streamList: testStreamList},
+	}
+	mplsPath := filepath.Join(bdmvPath, "PLAYLIST", "00000.mpls")
+	writeTestFile(t, mplsPath, buildMpls(itemList, nil))
+	writeTestFile(t, filepath.Join(bdmvPath, "CLIPINF", "00001.clpi"), buildClpi([][]uint32{{27000000}}))
+	writeTestFile(t, filepath.Join(bdmvPath, "CLIPINF", "00002.clpi"), buildClpi([][]uint32{{0, 1000000}}))
+
+	playlist, err := ParsePlaylist(mplsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clipRangeList, err := playlist.ClipRangeList()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []ClipRange{
+		{Path: filepath.Join(bdmvPath, "STREAM", "00001.m2ts"), Start: 0, End: 240},
+		{Path: filepath.Join(bdmvPath, "STREAM", "00002.m2ts"), Start: 24, End: 503},
+	}
+	if !reflect.DeepEqual(clipRangeList, expect) {
+		t.Errorf("got %v, expect %v", clipRangeList, expect)
+	}
+}
@@ -0,0 +1,246 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package chapter
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Chapter struct {
+	Start time.Duration
+	Name  string
+}
+
+func DefaultName(index int) string {
+	return fmt.Sprintf("Chapter %02d", index+1)
+}
+
+func FormatTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+func WriteOgm(path string, chapterList []Chapter) error {
+	ogmFile, err := os.Create(path)
+	if err != nil {
+		return errors.New("failed to create chapter file: " + err.Error())
+	}
+
+	for i, c := range chapterList {
+		_, err = fmt.Fprintf(ogmFile, "CHAPTER%02d=%s\nCHAPTER%02dNAME=%s\n", i+1, FormatTimestamp(c.Start), i+1, c.Name)
+		if err != nil {
+			_ = ogmFile.Close()
+			return errors.New("failed to write chapter file: " + err.Error())
+		}
+	}
+
+	return ogmFile.Close()
+}
+
+type xmlOutputChapters struct {
+	XMLName xml.Name `xml:"Chapters"`
+	Edition struct {
+		AtomList []xmlOutputAtom `xml:"ChapterAtom"`
+	} `xml:"EditionEntry"`
+}
+
+type xmlOutputAtom struct {
+	TimeStart string `xml:"ChapterTimeStart"`
+	Display   struct {
+		String   string `xml:"ChapterString"`
+		Language string `xml:"ChapterLanguage"`
+	} `xml:"ChapterDisplay"`
+}
+
+func WriteXml(path string, chapterList []Chapter) error {
+	var chapters xmlOutputChapters
+	for _, c := range chapterList {
+		atom := xmlOutputAtom{TimeStart: FormatTimestamp(c.Start)}
+		atom.Display.String = c.Name
+		atom.Display.Language = "und"
+		chapters.Edition.AtomList = append(chapters.Edition.AtomList, atom)
+	}
+
+	data, err := xml.MarshalIndent(&chapters, "", "  ")
+	if err != nil {
+		return errors.New("failed to encode chapter file: " + err.Error())
+	}
+
+	data = append([]byte(xml.Header+"<!DOCTYPE Chapters SYSTEM \"matroskachapters.dtd\">\n"), data...)
+	err = ioutil.WriteFile(path, append(data, '\n'), 0644)
+	if err != nil {
+		return errors.New("failed to write chapter file: " + err.Error())
+	}
+
+	return nil
+}
+
+var (
+	ogmTimeRegexp = regexp.MustCompile(`^CHAPTER(\d+)=(\d+):(\d{2}):(\d{2})(?:[.,](\d{1,9}))?$`)
+	ogmNameRegexp = regexp.MustCompile(`^CHAPTER(\d+)NAME=(.*)$`)
+	xmlTimeRegexp = regexp.MustCompile(`^(\d+):(\d{2}):(\d{2})(?:\.(\d{1,9}))?$`)
+)
+
+type xmlChapters struct {
+	EditionList []struct {
+		AtomList []xmlAtom `xml:"ChapterAtom"`
+	} `xml:"EditionEntry"`
+}
+
+type xmlAtom struct {
+	TimeStart   string `xml:"ChapterTimeStart"`
+	Hidden      int    `xml:"ChapterFlagHidden"`
+	DisplayList []struct {
+		String string `xml:"ChapterString"`
+	} `xml:"ChapterDisplay"`
+}
+
+func Read(path string) ([]Chapter, error) {
+	if strings.EqualFold(filepath.Ext(path), ".xml") {
+		return ReadXml(path)
+	}
+	return ReadOgm(path)
+}
+
+func ReadOgm(path string) ([]Chapter, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("failed to read chapter file: " + err.Error())
+	}
+
+	chapterMap := make(map[int]*Chapter)
+	text := strings.TrimPrefix(string(data), "\ufeff")
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+
+		if match := ogmTimeRegexp.FindStringSubmatch(line); len(match) == 6 {
+			index, _ := strconv.Atoi(match[1])
+			if _, exist := chapterMap[index]; !exist {
+				chapterMap[index] = &Chapter{}
+			}
+			chapterMap[index].Start = parseTimestamp(match[2], match[3], match[4], match[5])
+			continue
+		}
+
+		if match := ogmNameRegexp.FindStringSubmatch(line); len(match) == 3 {
+			index, _ := strconv.Atoi(match[1])
+			if _, exist := chapterMap[index]; !exist {
+				chapterMap[index] = &Chapter{}
+			}
+			chapterMap[index].Name = match[2]
+		}
+	}
+
+	indexList := make([]int, 0, len(chapterMap))
+	for index := range chapterMap {
+		indexList = append(indexList, index)
+	}
+	sort.Ints(indexList)
+
+	chapterList := make([]Chapter, 0, len(indexList))
+	for _, index := range indexList {
+		chapterList = append(chapterList, *chapterMap[index])
+	}
+
+	if len(chapterList) <= 0 {
+		return nil, errors.New("no chapter found in " + path)
+	}
+
+	return chapterList, nil
+}
+
+func ReadXml(path string) ([]Chapter, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("failed to read chapter file: " + err.Error())
+	}
+
+	var chapters xmlChapters
+	err = xml.Unmarshal(data, &chapters)
+	if err != nil {
+		return nil, errors.New("failed to parse chapter file: " + err.Error())
+	}
+
+	chapterList := make([]Chapter, 0)
+	if len(chapters.EditionList) > 0 {
+		for _, atom := range chapters.EditionList[0].AtomList {
+			if atom.Hidden != 0 {
+				continue
+			}
+
+			match := xmlTimeRegexp.FindStringSubmatch(strings.TrimSpace(atom.TimeStart))
+			if len(match) != 5 {
+				return nil, errors.New("invalid chapter time: " + atom.TimeStart)
+			}
+
+			c := Chapter{
+				Start: parseTimestamp(match[1], match[2], match[3], match[4]),
+				Name:  DefaultName(len(chapterList)),
+			}
+			if len(atom.DisplayList) > 0 {
+				c.Name = atom.DisplayList[0].String
+			}
+			chapterList = append(chapterList, c)
+		}
+	}
+
+	if len(chapterList) <= 0 {
+		return nil, errors.New("no chapter found in " + path)
+	}
+
+	return chapterList, nil
+}
+
+func Auto(duration time.Duration, interval time.Duration) []Chapter {
+	chapterList := make([]Chapter, 0)
+	if interval <= 0 {
+		return chapterList
+	}
+
+	for start := time.Duration(0); start == 0 || duration-start >= time.Second; start += interval {
+		chapterList = append(chapterList, Chapter{
+			Start: start,
+			Name:  DefaultName(len(chapterList)),
+		})
+	}
+
+	return chapterList
+}
+
+func parseTimestamp(hour string, minute string, second string, fraction string) time.Duration {
+	h, _ := strconv.Atoi(hour)
+	m, _ := strconv.Atoi(minute)
+	s, _ := strconv.Atoi(second)
+	d := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+	if fraction != "" {
+		f, _ := strconv.Atoi((fraction + "000000000")[:9])
+		d += time.Duration(f)
+	}
+	return d
+}
@@ -0,0 +1,131 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type BatchTask struct {
+	Task    `yaml:",inline"`
+	Sources []string `json:"sources" yaml:"sources" toml:"sources"`
+	Glob    string   `json:"glob" yaml:"glob" toml:"glob"`
+	Items   []Task   `json:"items" yaml:"items" toml:"items"`
+}
+
+func (b *BatchTask) IsBatch() bool {
+	return len(b.Sources) > 0 || b.Glob != "" || len(b.Items) > 0
+}
+
+func (b *BatchTask) Expand(baseDirPath string) ([]*Task, error) {
+	srcList := make([]string, 0)
+	srcList = append(srcList, b.Sources...)
+
+	if b.Glob != "" {
+		pattern := b.Glob
+		if baseDirPath != "" && !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(baseDirPath, pattern)
+		}
+
+		matchList, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, errors.New("invalid batch glob: " + err.Error())
+		}
+		if len(matchList) <= 0 {
+			return nil, errors.New("batch glob matches nothing: " + b.Glob)
+		}
+		sort.Strings(matchList)
+		srcList = append(srcList, matchList...)
+	}
+
+	itemMap := make(map[string]*Task)
+	for i := range b.Items {
+		item := &b.Items[i]
+		if item.Src == "" {
+			return nil, fmt.Errorf("batch item #%d has no src", i)
+		}
+		if _, exist := itemMap[item.Src]; !exist {
+			srcList = append(srcList, item.Src)
+		}
+		itemMap[item.Src] = item
+	}
+
+	taskList := make([]*Task, 0, len(srcList))
+	srcSet := make(map[string]bool)
+	for _, src := range srcList {
+		if srcSet[src] {
+			continue
+		}
+		srcSet[src] = true
+
+		task := b.Task.Clone()
+		task.Src = src
+		task.markKey("src")
+		if item, exist := itemMap[src]; exist {
+			itemTask := item.Clone()
+			MergeTask(&task, &itemTask)
+		}
+		taskList = append(taskList, &task)
+	}
+
+	if len(taskList) <= 0 {
+		return nil, errors.New("batch contains no source")
+	}
+
+	return taskList, nil
+}
+
+func NewTasksFromFile(taskPath string) ([]*Task, error) {
+	var batch BatchTask
+	err := DecodeTaskFile(taskPath, &batch)
+	if err != nil {
+		return nil, err
+	}
+
+	return expandBatch(&batch, strings.TrimSuffix(filepath.Base(taskPath), filepath.Ext(taskPath)), filepath.Dir(taskPath))
+}
+
+func NewTasksFromData(data []byte, ext string, name string) ([]*Task, error) {
+	var batch BatchTask
+	err := DecodeTaskData(data, ext, &batch)
+	if err != nil {
+		return nil, err
+	}
+
+	return expandBatch(&batch, name, "")
+}
+
+func expandBatch(batch *BatchTask, name string, baseDirPath string) ([]*Task, error) {
+	if !batch.IsBatch() {
+		batch.Task.resultList = make([]Result, 0)
+		return []*Task{&batch.Task}, nil
+	}
+
+	if batch.Batch == "" {
+		batch.Batch = fmt.Sprintf("%s-%s", name, time.Now().Format("20060102150405"))
+		batch.Task.markKey("batch")
+	}
+
+	return batch.Expand(baseDirPath)
+}
@@ -0,0 +1,136 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type TaskDecoder func([]byte, interface{}) error
+
+type documentKeyed interface {
+	setDocumentKeys(document map[string]interface{})
+}
+
+var TaskDecoderMap = map[string]TaskDecoder{
+	".json": decodeJson,
+	".yaml": decodeYaml,
+	".yml":  decodeYaml,
+	".toml": decodeToml,
+}
+
+func IsTaskFile(path string) bool {
+	_, exist := TaskDecoderMap[strings.ToLower(filepath.Ext(path))]
+	return exist
+}
+
+func DecodeTaskData(data []byte, ext string, v interface{}) error {
+	decoder, exist := TaskDecoderMap[strings.ToLower(ext)]
+	if !exist {
+		return errors.New("unsupported task file format: " + ext)
+	}
+
+	err := decoder(data, v)
+	if err != nil {
+		return err
+	}
+
+	if keyed, ok := v.(documentKeyed); ok {
+		var document map[string]interface{}
+		err = decoder(data, &document)
+		if err != nil {
+			return err
+		}
+		keyed.setDocumentKeys(document)
+	}
+
+	return nil
+}
+
+func DecodeTaskFile(path string, v interface{}) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return errors.New("task file path not exist")
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.New("failed to read task file: " + err.Error())
+	}
+
+	return DecodeTaskData(data, filepath.Ext(path), v)
+}
+
+func decodeJson(data []byte, v interface{}) error {
+	err := json.Unmarshal(data, v)
+	if err != nil {
+		return errors.New("failed to unmarshal json task: " + err.Error())
+	}
+	return nil
+}
+
+func decodeYaml(data []byte, v interface{}) error {
+	err := yaml.Unmarshal(data, v)
+	if err != nil {
+		return errors.New("failed to unmarshal yaml task: " + err.Error())
+	}
+	return nil
+}
+
+func decodeToml(data []byte, v interface{}) error {
+	_, err := toml.Decode(string(data), v)
+	if err != nil {
+		return errors.New("failed to unmarshal toml task: " + err.Error())
+	}
+	return nil
+}
+
+func (t *Task) setDocumentKeys(document map[string]interface{}) {
+	t.keySet = make(map[string]bool, len(document))
+	for key := range document {
+		t.keySet[key] = true
+	}
+}
+
+func (b *BatchTask) setDocumentKeys(document map[string]interface{}) {
+	b.Task.setDocumentKeys(document)
+
+	var itemDocumentList []map[string]interface{}
+	switch itemList := document["items"].(type) {
+	case []map[string]interface{}:
+		itemDocumentList = itemList
+	case []interface{}:
+		for _, item := range itemList {
+			itemDocument, _ := item.(map[string]interface{})
+			itemDocumentList = append(itemDocumentList, itemDocument)
+		}
+	}
+
+	for i := range b.Items {
+		if i < len(itemDocumentList) {
+			b.Items[i].setDocumentKeys(itemDocumentList[i])
+		}
+	}
+}
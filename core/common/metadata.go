@@ -0,0 +1,71 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package common
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+func (t *Task) HasMetadata() bool {
+	return t.Metadata != nil
+}
+
+func (t *Task) GetMetadata() map[string]string {
+	tagMap := make(map[string]string)
+	if !t.HasMetadata() {
+		return tagMap
+	}
+
+	encoderSettings := strings.TrimSpace(t.Video + " " + t.Param)
+	if encoderSettings != "" {
+		tagMap["ENCODER_SETTINGS"] = encoderSettings
+	}
+
+	tagMap["SOURCE"] = filepath.Base(t.Src)
+	if disc := discName(t.Src); disc != "" {
+		tagMap["SOURCE_DISC"] = disc
+	}
+
+	for k, v := range t.Metadata {
+		k = strings.ToUpper(strings.TrimSpace(k))
+		if k == "" {
+			continue
+		}
+		if v == "" {
+			delete(tagMap, k)
+			continue
+		}
+		tagMap[k] = v
+	}
+
+	return tagMap
+}
+
+func discName(src string) string {
+	elemList := strings.FieldsFunc(filepath.ToSlash(src), func(r rune) bool {
+		return r == '/' || r == '\\'
+	})
+	for i := len(elemList) - 1; i > 0; i-- {
+		if strings.EqualFold(elemList[i], "BDMV") && !strings.HasSuffix(elemList[i-1], ":") {
+			return elemList[i-1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,228 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"gopkg.in/yaml.v3"
+	"strings"
+)
+
+type MuxSpec struct {
+	Format     string   `json:"format" yaml:"format" toml:"format"`
+	Suffix     string   `json:"suffix,omitempty" yaml:"suffix,omitempty" toml:"suffix,omitempty"`
+	Languages  []string `json:"languages,omitempty" yaml:"languages,omitempty" toml:"languages,omitempty"`
+	Codecs     []string `json:"codecs,omitempty" yaml:"codecs,omitempty" toml:"codecs,omitempty"`
+	Categories []string `json:"categories,omitempty" yaml:"categories,omitempty" toml:"categories,omitempty"`
+}
+
+type MuxList []MuxSpec
+
+var resultCategoryNameMap = map[ResultCategory]string{
+	ResultVideo:      "video",
+	ResultAudio:      "audio",
+	ResultSubtitle:   "subtitle",
+	ResultChapters:   "chapters",
+	ResultAttachment: "attachment",
+	ResultTags:       "tags",
+}
+
+var codecFamilyMap = map[string]string{
+	"fdkaac":    "aac",
+	"fdkaac-he": "aac",
+	"ffaac":     "aac",
+	"ac3-copy":  "ac3",
+	"m4a":       "aac",
+	"pcm":       "wav",
+	"truehd":    "thd",
+	"264":       "avc",
+	"ssa":       "ass",
+}
+
+func (c ResultCategory) Name() string {
+	return resultCategoryNameMap[c]
+}
+
+func CodecFamily(codec string) string {
+	codec = strings.ToLower(strings.TrimPrefix(codec, "."))
+	if family, exist := codecFamilyMap[codec]; exist {
+		return family
+	}
+	return codec
+}
+
+func (s *MuxSpec) Match(category ResultCategory, lang string, codec string) bool {
+	if len(s.Categories) > 0 && !containsFold(s.Categories, category.Name()) {
+		return false
+	}
+
+	if category != ResultAudio && category != ResultSubtitle {
+		return true
+	}
+
+	if len(s.Languages) > 0 && !containsFold(s.Languages, lang) {
+		return false
+	}
+
+	if len(s.Codecs) > 0 {
+		matched := false
+		for _, c := range s.Codecs {
+			if CodecFamily(c) == CodecFamily(codec) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *MuxSpec) Validate() error {
+	for _, c := range s.Categories {
+		found := false
+		for _, name := range resultCategoryNameMap {
+			if strings.EqualFold(c, name) {
+				found = true
+			}
+		}
+		if !found {
+			return errors.New("unknown mux category: " + c)
+		}
+	}
+	return nil
+}
+
+func (s *MuxSpec) UnmarshalJSON(data []byte) error {
+	var format string
+	if json.Unmarshal(data, &format) == nil {
+		*s = MuxSpec{Format: format}
+		return nil
+	}
+
+	type plainSpec MuxSpec
+	var spec plainSpec
+	err := json.Unmarshal(data, &spec)
+	if err != nil {
+		return err
+	}
+	*s = MuxSpec(spec)
+	return nil
+}
+
+func (s *MuxSpec) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*s = MuxSpec{Format: value.Value}
+		return nil
+	}
+
+	type plainSpec MuxSpec
+	var spec plainSpec
+	err := value.Decode(&spec)
+	if err != nil {
+		return err
+	}
+	*s = MuxSpec(spec)
+	return nil
+}
+
+func (l *MuxList) UnmarshalJSON(data []byte) error {
+	var format string
+	if json.Unmarshal(data, &format) == nil {
+		*l = newMuxList(format)
+		return nil
+	}
+
+	var spec MuxSpec
+	if json.Unmarshal(data, &spec) == nil {
+		*l = MuxList{spec}
+		return nil
+	}
+
+	var specList []MuxSpec
+	err := json.Unmarshal(data, &specList)
+	if err != nil {
+		return errors.New("mux should be a format, an output spec or a list of output specs")
+	}
+	*l = specList
+	return nil
+}
+
+func (l *MuxList) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		var format string
+		err := value.Decode(&format)
+		if err != nil {
+			return err
+		}
+		*l = newMuxList(format)
+		return nil
+	case yaml.MappingNode:
+		var spec MuxSpec
+		err := value.Decode(&spec)
+		if err != nil {
+			return err
+		}
+		*l = MuxList{spec}
+		return nil
+	}
+
+	var specList []MuxSpec
+	err := value.Decode(&specList)
+	if err != nil {
+		return err
+	}
+	*l = specList
+	return nil
+}
+
+func (l *MuxList) UnmarshalTOML(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return l.UnmarshalJSON(data)
+}
+
+func (l MuxList) MarshalJSON() ([]byte, error) {
+	if len(l) == 1 && l[0].Suffix == "" && len(l[0].Languages) == 0 && len(l[0].Codecs) == 0 && len(l[0].Categories) == 0 {
+		return json.Marshal(l[0].Format)
+	}
+	return json.Marshal([]MuxSpec(l))
+}
+
+func newMuxList(format string) MuxList {
+	if format == "" {
+		return nil
+	}
+	return MuxList{{Format: format}}
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	OutputCollisionSuffix    = "suffix"
+	OutputCollisionOverwrite = "overwrite"
+	OutputCollisionFail      = "fail"
+)
+
+var (
+	outputFieldRegexp   = regexp.MustCompile(`\{(\w+)\}`)
+	outputCrfRegexp     = regexp.MustCompile(`--crf[ =]([\d.]+)`)
+	invalidCharReplacer = strings.NewReplacer("<", "_", ">", "_", ":", "_", "\"", "_", "|", "_", "?", "_", "*", "_")
+)
+
+var outputFieldSet = map[string]bool{
+	"show":         true,
+	"episode":      true,
+	"src_basename": true,
+	"codec":        true,
+	"crf":          true,
+	"date":         true,
+	"batch":        true,
+}
+
+type OutputFile struct {
+	SrcPath string
+	Name    string
+	Rest    string
+}
+
+func (t *Task) ValidateOutput() error {
+	switch t.OutputCollision {
+	case "", OutputCollisionSuffix, OutputCollisionOverwrite, OutputCollisionFail:
+	default:
+		return errors.New("unknown output collision policy: " + t.OutputCollision)
+	}
+
+	for _, match := range outputFieldRegexp.FindAllStringSubmatch(t.OutputName, -1) {
+		if !outputFieldSet[match[1]] {
+			return errors.New("unknown output name field: " + match[0])
+		}
+	}
+
+	for _, elem := range splitOutputPath(t.OutputName) {
+		if elem == ".." {
+			return errors.New("output name can not leave the output dir: " + t.OutputName)
+		}
+	}
+	if filepath.IsAbs(t.OutputName) || filepath.VolumeName(t.OutputName) != "" {
+		return errors.New("output name must be relative: " + t.OutputName)
+	}
+
+	return nil
+}
+
+func (t *Task) RenderOutputName(now time.Time) (string, error) {
+	srcBaseName := filepath.Base(strings.Replace(t.Src, "\\", "/", -1))
+	fieldMap := map[string]string{
+		"show":         t.Show,
+		"episode":      t.Episode,
+		"src_basename": strings.TrimSuffix(srcBaseName, filepath.Ext(srcBaseName)),
+		"codec":        t.Video,
+		"crf":          "",
+		"date":         now.Format("2006-01-02"),
+		"batch":        t.Batch,
+	}
+	if match := outputCrfRegexp.FindStringSubmatch(t.Param); len(match) == 2 {
+		fieldMap["crf"] = match[1]
+	}
+
+	rendered := outputFieldRegexp.ReplaceAllStringFunc(t.OutputName, func(field string) string {
+		return fieldMap[strings.Trim(field, "{}")]
+	})
+
+	elemList := make([]string, 0)
+	for _, elem := range splitOutputPath(rendered) {
+		elem = strings.Trim(invalidCharReplacer.Replace(elem), " .")
+		if elem != "" {
+			elemList = append(elemList, elem)
+		}
+	}
+	if len(elemList) <= 0 {
+		return "", fmt.Errorf("output name %q renders to an empty name", t.OutputName)
+	}
+
+	return filepath.Join(elemList...), nil
+}
+
+func ResolveOutputFiles(outputDirPath string, fileList []OutputFile, policy string) ([]string, error) {
+	for n := 1; ; n++ {
+		suffix := ""
+		if n > 1 {
+			suffix = fmt.Sprintf(" (%d)", n)
+		}
+
+		dstPathList := make([]string, 0, len(fileList))
+		collision := ""
+		for _, file := range fileList {
+			dstPath := filepath.Join(outputDirPath, file.Name+suffix+file.Rest)
+			if _, err := os.Stat(dstPath); err == nil && collision == "" {
+				collision = dstPath
+			}
+			dstPathList = append(dstPathList, dstPath)
+		}
+
+		if collision == "" || policy == OutputCollisionOverwrite {
+			return dstPathList, nil
+		}
+		if policy == OutputCollisionFail {
+			return nil, errors.New("output file already exists: " + collision)
+		}
+	}
+}
+
+func splitOutputPath(path string) []string {
+	return strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/' || r == '\\'
+	})
+}
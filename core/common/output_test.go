@@ -0,0 +1,183 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package common
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRenderOutputName(t *testing.T) {
+	now := time.Date(2021, 7, 1, 12, 0, 0, 0, time.UTC)
+	testList := []struct {
+		name       string
+		task       Task
+		expect     string
+		isError    bool
+		isNotValid bool
+	}{
+		{
+			name:   "all fields",
+			task:   Task{Src: "/src/[Group] Show - 01.m2ts", Show: "Show", Episode: "01", Video: "hevc", Param: "--preset slow --crf 18.5", Batch: "s1", OutputName: "{show}/{batch}/{show} - {episode} [{codec} crf{crf}] {date} {src_basename}"},
+			expect: filepath.Join("Show", "s1", "Show - 01 [hevc crf18.5] 2021-07-01 [Group] Show - 01"),
+		},
+		{
+			name:   "windows source path",
+			task:   Task{Src: `D:\src\episode.01.mkv`, OutputName: "{src_basename}"},
+			expect: "episode.01",
+		},
+		{
+			name:   "crf with equal sign",
+			task:   Task{Src: "a.mkv", Param: "--crf=20", OutputName: "a {crf}"},
+			expect: "a 20",
+		},
+		{
+			name:   "invalid characters and empty elements",
+			task:   Task{Src: "a.mkv", Show: "What? Show: 2", OutputName: "{show}//{episode}/. {src_basename}."},
+			expect: filepath.Join("What_ Show_ 2", "a"),
+		},
+		{
+			name:    "renders to an empty name",
+			task:    Task{Src: "a.mkv", OutputName: "{show}/{episode}"},
+			isError: true,
+		},
+		{
+			name:       "unknown field",
+			task:       Task{Src: "a.mkv", OutputName: "{title}"},
+			isNotValid: true,
+		},
+		{
+			name:       "leaves the output dir",
+			task:       Task{Src: "a.mkv", OutputName: "../{src_basename}"},
+			isNotValid: true,
+		},
+		{
+			name:       "absolute path",
+			task:       Task{Src: "a.mkv", OutputName: "/{src_basename}"},
+			isNotValid: true,
+		},
+		{
+			name:       "unknown collision policy",
+			task:       Task{Src: "a.mkv", OutputName: "{src_basename}", OutputCollision: "rename"},
+			isNotValid: true,
+		},
+	}
+
+	for _, test := range testList {
+		err := test.task.ValidateOutput()
+		if test.isNotValid {
+			if err == nil {
+				t.Errorf("%s: expect a validation error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+			continue
+		}
+
+		outputName, err := test.task.RenderOutputName(now)
+		if test.isError {
+			if err == nil {
+				t.Errorf("%s: expect an error, got %q", test.name, outputName)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+			continue
+		}
+		if outputName != test.expect {
+			t.Errorf("%s: got %q, expect %q", test.name, outputName, test.expect)
+		}
+	}
+}
+
+func TestResolveOutputFiles(t *testing.T) {
+	dirPath := t.TempDir()
+	for _, name := range []string{"show.mkv", "show (2).ass", "other.mkv"} {
+		if err := ioutil.WriteFile(filepath.Join(dirPath, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fileList := []OutputFile{{Name: "show", Rest: ".mkv"}, {Name: "show", Rest: ".ass"}}
+	testList := []struct {
+		name     string
+		fileList []OutputFile
+		policy   string
+		expect   []string
+		isError  bool
+	}{
+		{
+			name:     "no collision",
+			fileList: []OutputFile{{Name: "new", Rest: ".mkv"}, {Name: "new", Rest: ".en.ass"}},
+			policy:   OutputCollisionFail,
+			expect:   []string{"new.mkv", "new.en.ass"},
+		},
+		{
+			name:     "suffix skips any taken group member",
+			fileList: fileList,
+			policy:   OutputCollisionSuffix,
+			expect:   []string{"show (3).mkv", "show (3).ass"},
+		},
+		{
+			name:     "empty policy means suffix",
+			fileList: []OutputFile{{Name: "other", Rest: ".mkv"}},
+			expect:   []string{"other (2).mkv"},
+		},
+		{
+			name:     "overwrite",
+			fileList: fileList,
+			policy:   OutputCollisionOverwrite,
+			expect:   []string{"show.mkv", "show.ass"},
+		},
+		{
+			name:     "fail",
+			fileList: fileList,
+			policy:   OutputCollisionFail,
+			isError:  true,
+		},
+	}
+
+	for _, test := range testList {
+		dstPathList, err := ResolveOutputFiles(dirPath, test.fileList, test.policy)
+		if test.isError {
+			if err == nil {
+				t.Errorf("%s: expect an error, got %q", test.name, dstPathList)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+			continue
+		}
+
+		expect := make([]string, 0, len(test.expect))
+		for _, name := range test.expect {
+			expect = append(expect, filepath.Join(dirPath, name))
+		}
+		if !reflect.DeepEqual(dstPathList, expect) {
+			t.Errorf("%s: got %q, expect %q", test.name, dstPathList, expect)
+		}
+	}
+}
@@ -20,10 +20,16 @@ package common
 
 type Parameter struct {
 	WorkerNum      int
+	MiscJobNum     int
 	MonitorDirPath string
 	WorkDirPath    string
 	OutputDirPath  string
+	PresetDirPath  string
+	BdmvPreset     string
+	BdmvAudioLangs string
+	BdmvSubLangs   string
 	Ip             string
 	Port           string
 	ActiveTime     string
+	NotifyUrl      string
 }
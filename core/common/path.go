@@ -20,6 +20,7 @@ package common
 
 import (
 	"errors"
+	"log"
 	"os"
 	"path/filepath"
 )
@@ -33,6 +34,15 @@ var binPathMap = map[string]string{
 	"qaacPath":     "qaac.exe",
 	"mkvmergePath": "mkvtoolnix\\mkvmerge.exe",
 	"lsmashPath":   "lsmashmuxer.exe",
+	"ffmpegPath":   "ffmpeg.exe",
+	"fdkaacPath":   "fdkaac.exe",
+	"mp4boxPath":   "mp4box.exe",
+}
+
+var optionalToolSet = map[string]bool{
+	"ffmpegPath": true,
+	"fdkaacPath": true,
+	"mp4boxPath": true,
 }
 
 func init() {
@@ -45,14 +55,23 @@ func init() {
 }
 
 func CheckToolsAvailability() error {
-	for _, path := range binPathMap {
+	for name, path := range binPathMap {
 		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if optionalToolSet[name] {
+				log.Printf("[warning] %s not exist, codecs relying on it are unavailable\n", path)
+				continue
+			}
 			return errors.New(path + " not exist")
 		}
 	}
 	return nil
 }
 
+func IsToolAvailable(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
 func GetEac3toPath() string {
 	return binPathMap["eac3toPath"]
 }
@@ -84,3 +103,15 @@ func GetMkvmergePath() string {
 func GetLsmashPath() string {
 	return binPathMap["lsmashPath"]
 }
+
+func GetFFmpegPath() string {
+	return binPathMap["ffmpegPath"]
+}
+
+func GetFdkaacPath() string {
+	return binPathMap["fdkaacPath"]
+}
+
+func GetMp4boxPath() string {
+	return binPathMap["mp4boxPath"]
+}
@@ -0,0 +1,189 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+func FindPresetFile(presetDirPath string, name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, "/\\") || name == "." || name == ".." {
+		return "", errors.New("invalid preset name: " + name)
+	}
+
+	extList := make([]string, 0, len(TaskDecoderMap))
+	for ext := range TaskDecoderMap {
+		extList = append(extList, ext)
+	}
+	sort.Strings(extList)
+
+	for _, ext := range extList {
+		presetPath := filepath.Join(presetDirPath, name+ext)
+		if _, err := os.Stat(presetPath); err == nil {
+			return presetPath, nil
+		}
+	}
+
+	return "", errors.New("preset not found: " + name)
+}
+
+func LoadPreset(presetDirPath string, name string) (*Task, error) {
+	chain := make([]Task, 0)
+	visited := make(map[string]bool)
+
+	for name != "" {
+		if visited[name] {
+			return nil, errors.New("preset inheritance cycle at: " + name)
+		}
+		visited[name] = true
+
+		presetPath, err := FindPresetFile(presetDirPath, name)
+		if err != nil {
+			return nil, err
+		}
+
+		var preset Task
+		err = DecodeTaskFile(presetPath, &preset)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load preset %s: %s", name, err.Error())
+		}
+
+		chain = append(chain, preset)
+		name = preset.Preset
+	}
+
+	var effective Task
+	for i := len(chain) - 1; i >= 0; i-- {
+		MergeTask(&effective, &chain[i])
+	}
+
+	return &effective, nil
+}
+
+func ApplyPreset(task *Task, presetDirPath string) error {
+	if task.Preset == "" {
+		return nil
+	}
+
+	preset, err := LoadPreset(presetDirPath, task.Preset)
+	if err != nil {
+		return err
+	}
+
+	MergeTask(preset, task)
+	MergeTask(task, preset)
+
+	return nil
+}
+
+func MergeTask(base *Task, override *Task) {
+	baseValue := reflect.ValueOf(base).Elem()
+	overrideValue := reflect.ValueOf(override).Elem()
+	taskType := baseValue.Type()
+
+	overrideKeySet := override.presentKeys()
+	keySet := base.presentKeys()
+	for i := 0; i < taskType.NumField(); i++ {
+		key, exist := taskFieldKey(taskType.Field(i))
+		if !exist || !overrideKeySet[key] {
+			continue
+		}
+
+		baseValue.Field(i).Set(overrideValue.Field(i))
+		keySet[key] = true
+	}
+	base.keySet = keySet
+}
+
+func (t *Task) presentKeys() map[string]bool {
+	keySet := make(map[string]bool)
+	if t.keySet != nil {
+		for key := range t.keySet {
+			keySet[key] = true
+		}
+		return keySet
+	}
+
+	taskValue := reflect.ValueOf(t).Elem()
+	taskType := taskValue.Type()
+	for i := 0; i < taskType.NumField(); i++ {
+		key, exist := taskFieldKey(taskType.Field(i))
+		if !exist {
+			continue
+		}
+
+		value := taskValue.Field(i)
+		if value.IsZero() {
+			continue
+		}
+		if value.Kind() == reflect.Slice && value.Len() == 0 {
+			continue
+		}
+		keySet[key] = true
+	}
+	return keySet
+}
+
+func (t *Task) markKey(key string) {
+	if t.keySet == nil {
+		return
+	}
+
+	keySet := make(map[string]bool, len(t.keySet)+1)
+	for k := range t.keySet {
+		keySet[k] = true
+	}
+	keySet[key] = true
+	t.keySet = keySet
+}
+
+func taskFieldKey(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+
+	key := strings.Split(field.Tag.Get("json"), ",")[0]
+	if key == "" || key == "-" {
+		return "", false
+	}
+	return key, true
+}
+
+func WriteEffectiveTask(task *Task, workDirPath string) (string, error) {
+	data, err := json.MarshalIndent(task, "", "    ")
+	if err != nil {
+		return "", errors.New("failed to marshal effective task: " + err.Error())
+	}
+
+	effectivePath := GenerateNewFilePath(task.Src, workDirPath, "effective.json", "", 0)
+	err = ioutil.WriteFile(effectivePath, data, 0666)
+	if err != nil {
+		return "", errors.New("failed to write effective task: " + err.Error())
+	}
+
+	return effectivePath, nil
+}
@@ -0,0 +1,141 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package common
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func decodeTestTask(t *testing.T, data string, ext string) *Task {
+	var task Task
+	if err := DecodeTaskData([]byte(data), ext, &task); err != nil {
+		t.Fatal(err)
+	}
+	return &task
+}
+
+func TestMergeTask(t *testing.T) {
+	preset := `{"param": "--crf 18", "video": "hevc", "skip_verify": true, "fonts": ["a.ttf"], "audio": [{"track": 2, "codec": "aac"}]}`
+
+	testList := []struct {
+		name   string
+		data   string
+		ext    string
+		expect func(task *Task) bool
+	}{
+		{
+			name: "absent keys keep the preset",
+			data: `{"src": "a.mkv"}`,
+			ext:  ".json",
+			expect: func(task *Task) bool {
+				return task.Src == "a.mkv" && task.Param == "--crf 18" && task.SkipVerify && len(task.Audio) == 1
+			},
+		},
+		{
+			name: "zero values in the document override the preset",
+			data: `{"src": "a.mkv", "skip_verify": false, "audio": [], "param": ""}`,
+			ext:  ".json",
+			expect: func(task *Task) bool {
+				return !task.SkipVerify && len(task.Audio) == 0 && task.Param == "" && task.Video == "hevc"
+			},
+		},
+		{
+			name: "yaml document",
+			data: "src: a.mkv\nskip_verify: false\nfonts: []\n",
+			ext:  ".yaml",
+			expect: func(task *Task) bool {
+				return !task.SkipVerify && len(task.Fonts) == 0 && task.Param == "--crf 18"
+			},
+		},
+		{
+			name: "toml document",
+			data: "src = \"a.mkv\"\nvideo = \"avc\"\nskip_verify = false\n",
+			ext:  ".toml",
+			expect: func(task *Task) bool {
+				return !task.SkipVerify && task.Video == "avc" && len(task.Fonts) == 1
+			},
+		},
+	}
+
+	for _, test := range testList {
+		base := decodeTestTask(t, preset, ".json")
+		task := decodeTestTask(t, test.data, test.ext)
+		MergeTask(base, task)
+		if !test.expect(base) {
+			t.Errorf("%s: got %+v", test.name, *base)
+		}
+	}
+}
+
+func TestMergeTaskKeySet(t *testing.T) {
+	base := decodeTestTask(t, `{"video": "hevc", "param": "--crf 18"}`, ".json")
+	MergeTask(base, decodeTestTask(t, `{"sfv": false}`, ".json"))
+
+	expect := map[string]bool{"video": true, "param": true, "sfv": true}
+	if keySet := base.presentKeys(); !reflect.DeepEqual(keySet, expect) {
+		t.Errorf("got %v, expect %v", keySet, expect)
+	}
+
+	override := Task{Video: "avc"}
+	MergeTask(base, &override)
+	if base.Video != "avc" || base.Param != "--crf 18" {
+		t.Errorf("task built in code should merge its non-zero fields, got %+v", *base)
+	}
+}
+
+func TestLoadPreset(t *testing.T) {
+	dirPath := t.TempDir()
+	presetMap := map[string]string{
+		"base.json":  `{"video": "hevc", "param": "--crf 18", "skip_verify": true}`,
+		"anime.yaml": "preset: base\nparam: --crf 20\n",
+		"fast.toml":  "preset = \"anime\"\nskip_verify = false\n",
+		"loop.json":  `{"preset": "loop2"}`,
+		"loop2.json": `{"preset": "loop"}`,
+	}
+	for name, data := range presetMap {
+		if err := ioutil.WriteFile(filepath.Join(dirPath, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	preset, err := LoadPreset(dirPath, "fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preset.Video != "hevc" || preset.Param != "--crf 20" || preset.SkipVerify {
+		t.Errorf("got %+v", *preset)
+	}
+
+	task := decodeTestTask(t, `{"src": "a.mkv", "preset": "anime", "video": "avc"}`, ".json")
+	if err = ApplyPreset(task, dirPath); err != nil {
+		t.Fatal(err)
+	}
+	if task.Src != "a.mkv" || task.Video != "avc" || task.Param != "--crf 20" || !task.SkipVerify {
+		t.Errorf("got %+v", *task)
+	}
+
+	for _, name := range []string{"loop", "missing", "../base"} {
+		if _, err = LoadPreset(dirPath, name); err == nil {
+			t.Errorf("%s: expect an error", name)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type TrackType string
+
+const (
+	TrackVideo    TrackType = "video"
+	TrackAudio    TrackType = "audio"
+	TrackSubtitle TrackType = "subtitle"
+	TrackChapters TrackType = "chapters"
+)
+
+type TrackInfo struct {
+	Track       uint      `json:"track"`
+	Type        TrackType `json:"type"`
+	Codec       string    `json:"codec"`
+	Language    string    `json:"language"`
+	Channels    string    `json:"channels"`
+	Bitrate     uint      `json:"bitrate"`
+	SampleRate  uint      `json:"samplerate"`
+	Delay       int       `json:"delay"`
+	Description string    `json:"description"`
+}
+
+type SourceInfo struct {
+	Src        string      `json:"src"`
+	Container  string      `json:"container"`
+	DurationMs int64       `json:"duration_ms"`
+	FPSNum     uint        `json:"fps_num"`
+	FPSDen     uint        `json:"fps_den"`
+	TrackList  []TrackInfo `json:"tracks"`
+}
+
+type TrackSelector struct {
+	Language string `json:"language" yaml:"language" toml:"language"`
+	Codec    string `json:"codec" yaml:"codec" toml:"codec"`
+}
+
+func (s *SourceInfo) GetTrack(track uint) (TrackInfo, bool) {
+	for _, trackInfo := range s.TrackList {
+		if trackInfo.Track == track {
+			return trackInfo, true
+		}
+	}
+	return TrackInfo{}, false
+}
+
+func (s *SourceInfo) SelectTrack(trackType TrackType, selector *TrackSelector, usedTrackSet map[uint]bool) (TrackInfo, bool) {
+	for _, trackInfo := range s.TrackList {
+		if trackInfo.Type != trackType || usedTrackSet[trackInfo.Track] {
+			continue
+		}
+		if selector.Language != "" && !strings.EqualFold(trackInfo.Language, selector.Language) {
+			continue
+		}
+		if selector.Codec != "" && !strings.HasPrefix(trackInfo.Codec, strings.ToLower(selector.Codec)) {
+			continue
+		}
+		return trackInfo, true
+	}
+	return TrackInfo{}, false
+}
+
+func (t *Task) HasTrackSelector() bool {
+	for _, audioTask := range t.Audio {
+		if audioTask.Select != nil {
+			return true
+		}
+	}
+	for _, demuxTask := range t.Demux {
+		if demuxTask.Select != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Task) ResolveTracks() error {
+	if t.SourceInfo == nil {
+		if t.HasTrackSelector() {
+			return errors.New("track selector requires source info")
+		}
+		return nil
+	}
+
+	usedTrackSet := make(map[uint]bool)
+	for _, audioTask := range t.Audio {
+		if audioTask.Select == nil {
+			usedTrackSet[audioTask.Track] = true
+		}
+	}
+	for i := range t.Audio {
+		audioTask := &t.Audio[i]
+		if audioTask.Select == nil {
+			continue
+		}
+
+		trackInfo, found := t.SourceInfo.SelectTrack(TrackAudio, audioTask.Select, usedTrackSet)
+		if !found {
+			return fmt.Errorf("no audio track matches language %q codec %q", audioTask.Select.Language, audioTask.Select.Codec)
+		}
+
+		usedTrackSet[trackInfo.Track] = true
+		audioTask.Track = trackInfo.Track
+		if audioTask.Language == "" {
+			audioTask.Language = trackInfo.Language
+		}
+	}
+
+	usedTrackSet = make(map[uint]bool)
+	for _, demuxTask := range t.Demux {
+		if demuxTask.Select == nil {
+			usedTrackSet[demuxTask.Track] = true
+		}
+	}
+	for i := range t.Demux {
+		demuxTask := &t.Demux[i]
+		if demuxTask.Select == nil {
+			continue
+		}
+
+		trackInfo, found := t.SourceInfo.SelectTrack(TrackSubtitle, demuxTask.Select, usedTrackSet)
+		if !found {
+			return fmt.Errorf("no subtitle track matches language %q codec %q", demuxTask.Select.Language, demuxTask.Select.Codec)
+		}
+
+		usedTrackSet[trackInfo.Track] = true
+		demuxTask.Track = trackInfo.Track
+		if demuxTask.Language == "" {
+			demuxTask.Language = trackInfo.Language
+		}
+	}
+
+	for _, audioTask := range t.Audio {
+		trackInfo, found := t.SourceInfo.GetTrack(audioTask.Track)
+		if !found || trackInfo.Type != TrackAudio {
+			return fmt.Errorf("track %d is not an audio track", audioTask.Track)
+		}
+	}
+
+	for _, demuxTask := range t.Demux {
+		if _, found := t.SourceInfo.GetTrack(demuxTask.Track); !found {
+			return fmt.Errorf("track %d not exist", demuxTask.Track)
+		}
+	}
+
+	return nil
+}
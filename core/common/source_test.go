@@ -0,0 +1,134 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveTracks(t *testing.T) {
+	sourceInfo := &SourceInfo{TrackList: []TrackInfo{
+		{Track: 1, Type: TrackVideo, Codec: "h264"},
+		{Track: 2, Type: TrackAudio, Codec: "truehd", Language: "jpn"},
+		{Track: 3, Type: TrackAudio, Codec: "ac3", Language: "jpn"},
+		{Track: 4, Type: TrackAudio, Codec: "ac3", Language: "eng"},
+		{Track: 5, Type: TrackSubtitle, Codec: "pgs", Language: "jpn"},
+		{Track: 6, Type: TrackSubtitle, Codec: "pgs", Language: "eng"},
+	}}
+
+	testList := []struct {
+		name       string
+		audioList  []AudioTask
+		demuxList  []DemuxTask
+		audioTrack []uint
+		audioLang  []string
+		demuxTrack []uint
+		isError    bool
+	}{
+		{
+			name:       "select by language and codec",
+			audioList:  []AudioTask{{Select: &TrackSelector{Language: "jpn", Codec: "ac3"}}, {Select: &TrackSelector{Language: "eng"}}},
+			demuxList:  []DemuxTask{{Select: &TrackSelector{Language: "eng"}}},
+			audioTrack: []uint{3, 4},
+			audioLang:  []string{"jpn", "eng"},
+			demuxTrack: []uint{6},
+		},
+		{
+			name:       "selectors skip tracks already selected",
+			audioList:  []AudioTask{{Select: &TrackSelector{Language: "jpn"}}, {Select: &TrackSelector{Language: "jpn"}}},
+			audioTrack: []uint{2, 3},
+			audioLang:  []string{"jpn", "jpn"},
+		},
+		{
+			name:       "selectors skip tracks given by number",
+			audioList:  []AudioTask{{Select: &TrackSelector{Language: "jpn"}}, {Track: 2, Language: "jpn"}},
+			demuxList:  []DemuxTask{{Select: &TrackSelector{}}, {Track: 5}},
+			audioTrack: []uint{3, 2},
+			audioLang:  []string{"jpn", "jpn"},
+			demuxTrack: []uint{6, 5},
+		},
+		{
+			name:       "language of the task is kept",
+			audioList:  []AudioTask{{Select: &TrackSelector{Codec: "truehd"}, Language: "und"}},
+			audioTrack: []uint{2},
+			audioLang:  []string{"und"},
+		},
+		{
+			name:      "no track left",
+			audioList: []AudioTask{{Track: 4}, {Select: &TrackSelector{Language: "eng"}}},
+			isError:   true,
+		},
+		{
+			name:      "numbered track is not audio",
+			audioList: []AudioTask{{Track: 5}},
+			isError:   true,
+		},
+		{
+			name:      "numbered demux track does not exist",
+			demuxList: []DemuxTask{{Track: 9}},
+			isError:   true,
+		},
+	}
+
+	for _, test := range testList {
+		task := Task{SourceInfo: sourceInfo, Audio: test.audioList, Demux: test.demuxList}
+		err := task.ResolveTracks()
+		if test.isError {
+			if err == nil {
+				t.Errorf("%s: expect an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+			continue
+		}
+
+		audioTrack := make([]uint, 0)
+		audioLang := make([]string, 0)
+		for _, audioTask := range task.Audio {
+			audioTrack = append(audioTrack, audioTask.Track)
+			audioLang = append(audioLang, audioTask.Language)
+		}
+		demuxTrack := make([]uint, 0)
+		for _, demuxTask := range task.Demux {
+			demuxTrack = append(demuxTrack, demuxTask.Track)
+		}
+
+		if len(test.audioTrack) > 0 && (!reflect.DeepEqual(audioTrack, test.audioTrack) || !reflect.DeepEqual(audioLang, test.audioLang)) {
+			t.Errorf("%s: got audio %v %v, expect %v %v", test.name, audioTrack, audioLang, test.audioTrack, test.audioLang)
+		}
+		if len(test.demuxTrack) > 0 && !reflect.DeepEqual(demuxTrack, test.demuxTrack) {
+			t.Errorf("%s: got demux %v, expect %v", test.name, demuxTrack, test.demuxTrack)
+		}
+	}
+}
+
+func TestResolveTracksWithoutSourceInfo(t *testing.T) {
+	task := Task{Audio: []AudioTask{{Track: 2}}}
+	if err := task.ResolveTracks(); err != nil {
+		t.Errorf("numbered tracks: %s", err.Error())
+	}
+
+	task = Task{Audio: []AudioTask{{Select: &TrackSelector{Language: "jpn"}}}}
+	if err := task.ResolveTracks(); err == nil {
+		t.Errorf("selector: expect an error")
+	}
+}
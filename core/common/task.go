@@ -18,95 +18,157 @@
 
 package common
 
-import (
-	"bufio"
-	"encoding/json"
-	"errors"
-	"os"
-)
-
 type Task struct {
-	Src      string      `json:"src"`
-	Template string      `json:"template"`
-	Param    string      `json:"param"`
-	Video    string      `json:"video"`
-	Audio    []AudioTask `json:"audio"`
-	Demux    []DemuxTask `json:"demux"`
-	HardSub  string      `json:"hardsub"`
-	Mux      string      `json:"mux"`
-
-	TotalFrameNum uint
-	FPSNum        uint
-	FPSDen        uint
-	ScriptFile    string
-	TaskFile      string
-	MuxedFile     string
-	resultList    []Result
+	Preset          string                 `json:"preset" yaml:"preset" toml:"preset"`
+	Src             string                 `json:"src" yaml:"src" toml:"src"`
+	Template        string                 `json:"template" yaml:"template" toml:"template"`
+	Vars            map[string]interface{} `json:"vars" yaml:"vars" toml:"vars"`
+	Param           string                 `json:"param" yaml:"param" toml:"param"`
+	Video           string                 `json:"video" yaml:"video" toml:"video"`
+	VideoName       string                 `json:"video_name" yaml:"video_name" toml:"video_name"`
+	VideoDefault    *bool                  `json:"video_default,omitempty" yaml:"video_default,omitempty" toml:"video_default,omitempty"`
+	VideoForced     bool                   `json:"video_forced" yaml:"video_forced" toml:"video_forced"`
+	Audio           []AudioTask            `json:"audio" yaml:"audio" toml:"audio"`
+	Demux           []DemuxTask            `json:"demux" yaml:"demux" toml:"demux"`
+	HardSub         string                 `json:"hardsub" yaml:"hardsub" toml:"hardsub"`
+	Fonts           []string               `json:"fonts" yaml:"fonts" toml:"fonts"`
+	FontDir         string                 `json:"font_dir" yaml:"font_dir" toml:"font_dir"`
+	Chapters        string                 `json:"chapters" yaml:"chapters" toml:"chapters"`
+	ChapterInterval uint                   `json:"chapter_interval" yaml:"chapter_interval" toml:"chapter_interval"`
+	ChapterFormat   string                 `json:"chapter_format" yaml:"chapter_format" toml:"chapter_format"`
+	Metadata        map[string]string      `json:"metadata" yaml:"metadata" toml:"metadata"`
+	AttachScript    bool                   `json:"attach_script" yaml:"attach_script" toml:"attach_script"`
+	AttachTask      bool                   `json:"attach_task" yaml:"attach_task" toml:"attach_task"`
+	Mux             MuxList                `json:"mux" yaml:"mux" toml:"mux"`
+	Show            string                 `json:"show" yaml:"show" toml:"show"`
+	Episode         string                 `json:"episode" yaml:"episode" toml:"episode"`
+	OutputName      string                 `json:"output_name" yaml:"output_name" toml:"output_name"`
+	OutputCollision string                 `json:"output_collision" yaml:"output_collision" toml:"output_collision"`
+	SkipVerify      bool                   `json:"skip_verify" yaml:"skip_verify" toml:"skip_verify"`
+	Sfv             bool                   `json:"sfv" yaml:"sfv" toml:"sfv"`
+	Batch           string                 `json:"batch" yaml:"batch" toml:"batch"`
+
+	TotalFrameNum  uint        `json:"-" yaml:"-" toml:"-"`
+	SourceFrameNum uint        `json:"-" yaml:"-" toml:"-"`
+	FPSNum         uint        `json:"-" yaml:"-" toml:"-"`
+	FPSDen         uint        `json:"-" yaml:"-" toml:"-"`
+	ScriptFile     string      `json:"-" yaml:"-" toml:"-"`
+	TaskFile       string      `json:"-" yaml:"-" toml:"-"`
+	EffectiveFile  string      `json:"-" yaml:"-" toml:"-"`
+	MuxedFileList  []string    `json:"-" yaml:"-" toml:"-"`
+	SourceInfo     *SourceInfo `json:"-" yaml:"-" toml:"-"`
+	TrimList       []Trim      `json:"-" yaml:"-" toml:"-"`
+	TargetFPSNum   uint        `json:"-" yaml:"-" toml:"-"`
+	TargetFPSDen   uint        `json:"-" yaml:"-" toml:"-"`
+	resultList     []Result
+	keySet         map[string]bool
 }
 
 type AudioTask struct {
-	Track    uint   `json:"track"`
-	Codec    string `json:"codec"`
-	Bitrate  uint   `json:"bitrate"`
-	Language string `json:"language"`
+	Track      uint           `json:"track" yaml:"track" toml:"track"`
+	Select     *TrackSelector `json:"select,omitempty" yaml:"select,omitempty" toml:"select,omitempty"`
+	Codec      string         `json:"codec" yaml:"codec" toml:"codec"`
+	Bitrate    uint           `json:"bitrate" yaml:"bitrate" toml:"bitrate"`
+	Language   string         `json:"language" yaml:"language" toml:"language"`
+	Name       string         `json:"name" yaml:"name" toml:"name"`
+	Default    *bool          `json:"default,omitempty" yaml:"default,omitempty" toml:"default,omitempty"`
+	Forced     bool           `json:"forced" yaml:"forced" toml:"forced"`
+	DelayMode  string         `json:"delay_mode" yaml:"delay_mode" toml:"delay_mode"`
+	Channels   uint           `json:"channels" yaml:"channels" toml:"channels"`
+	Downmix    string         `json:"downmix" yaml:"downmix" toml:"downmix"`
+	SampleRate uint           `json:"samplerate" yaml:"samplerate" toml:"samplerate"`
+	Loudness   *Loudness      `json:"loudness,omitempty" yaml:"loudness,omitempty" toml:"loudness,omitempty"`
+
+	InputFile string `json:"-" yaml:"-" toml:"-"`
+}
+
+type Loudness struct {
+	TargetLufs float64 `json:"target_lufs" yaml:"target_lufs" toml:"target_lufs"`
+	TruePeak   float64 `json:"true_peak" yaml:"true_peak" toml:"true_peak"`
+	Lra        float64 `json:"lra" yaml:"lra" toml:"lra"`
 }
 
+type LoudnessInfo struct {
+	Integrated float64 `json:"integrated"`
+	TruePeak   float64 `json:"true_peak"`
+	Lra        float64 `json:"lra"`
+}
+
+const (
+	DelayModeMux    = "mux"
+	DelayModeEac3to = "eac3to"
+	DelayModeIgnore = "ignore"
+)
+
+const (
+	ChaptersNone = "none"
+	ChaptersAuto = "auto"
+)
+
+const (
+	ChapterFormatOgm = "ogm"
+	ChapterFormatXml = "xml"
+)
+
 type DemuxTask struct {
-	Track    uint   `json:"track"`
-	Format   string `json:"format"`
-	Language string `json:"language"`
+	Track    uint           `json:"track" yaml:"track" toml:"track"`
+	Select   *TrackSelector `json:"select,omitempty" yaml:"select,omitempty" toml:"select,omitempty"`
+	Format   string         `json:"format" yaml:"format" toml:"format"`
+	Language string         `json:"language" yaml:"language" toml:"language"`
+	Name     string         `json:"name" yaml:"name" toml:"name"`
+	Default  *bool          `json:"default,omitempty" yaml:"default,omitempty" toml:"default,omitempty"`
+	Forced   bool           `json:"forced" yaml:"forced" toml:"forced"`
+	Convert  string         `json:"convert" yaml:"convert" toml:"convert"`
+	Style    string         `json:"style" yaml:"style" toml:"style"`
+	Shift    int            `json:"shift" yaml:"shift" toml:"shift"`
 }
 
 type ResultCategory int
 
 const (
 	ResultVideo ResultCategory = iota
-	ResultNonVideo
+	ResultAudio
+	ResultSubtitle
+	ResultChapters
+	ResultAttachment
+	ResultTags
 )
 
 type Result struct {
-	Category ResultCategory
-	Path     string
-	Lang     string
-	Track    uint
+	Category   ResultCategory
+	Path       string
+	Lang       string
+	Track      uint
+	Name       string
+	Default    *bool
+	Forced     bool
+	Delay      int
+	SampleRate uint
+	Loudness   *LoudnessInfo
 }
 
-func NewTaskFromJson(jsonPath string) (*Task, error) {
-	if _, err := os.Stat(jsonPath); os.IsNotExist(err) {
-		return nil, errors.New("json path not exist")
-	}
-
-	jsonFile, err := os.Open(jsonPath)
-	if err != nil {
-		return nil, errors.New("failed to open json file: " + err.Error())
-	}
-	defer func() {
-		closeErr := jsonFile.Close()
-		if err == nil {
-			err = closeErr
+func (t Task) Clone() Task {
+	c := t
+	c.Audio = append([]AudioTask(nil), t.Audio...)
+	c.Demux = append([]DemuxTask(nil), t.Demux...)
+	c.Fonts = append([]string(nil), t.Fonts...)
+	c.Mux = append(MuxList(nil), t.Mux...)
+	c.MuxedFileList = append([]string(nil), t.MuxedFileList...)
+	if t.Metadata != nil {
+		c.Metadata = make(map[string]string, len(t.Metadata))
+		for k, v := range t.Metadata {
+			c.Metadata[k] = v
 		}
-	}()
-
-	jsonStr := ""
-	jsonScanner := bufio.NewScanner(jsonFile)
-	for jsonScanner.Scan() {
-		line := jsonScanner.Text()
-		jsonStr += line
 	}
-
-	if jsonScanner.Err() != nil {
-		return nil, errors.New("failed to read whole json file: " + jsonScanner.Err().Error())
-	}
-
-	var task Task
-	err = json.Unmarshal([]byte(jsonStr), &task)
-	if err != nil {
-		return nil, errors.New("failed to unmarshal json task: " + err.Error())
+	if t.Vars != nil {
+		c.Vars = make(map[string]interface{}, len(t.Vars))
+		for k, v := range t.Vars {
+			c.Vars[k] = v
+		}
 	}
-
-	task.resultList = make([]Result, 0)
-
-	return &task, nil
+	c.TrimList = append([]Trim(nil), t.TrimList...)
+	c.resultList = append(make([]Result, 0, len(t.resultList)), t.resultList...)
+	return c
 }
 
 func (t Task) GetResultList() []Result {
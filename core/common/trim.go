@@ -0,0 +1,119 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type Trim struct {
+	Start int
+	End   int
+}
+
+var trimRegexp = regexp.MustCompile(`\(\s*(-?\d+|None)\s*,\s*(-?\d+|None)\s*\)`)
+
+func ParseTrimList(s string) ([]Trim, error) {
+	matchList := trimRegexp.FindAllStringSubmatch(s, -1)
+	if len(matchList) <= 0 {
+		return nil, errors.New("no trim range found in " + strings.TrimSpace(s))
+	}
+
+	trimList := make([]Trim, 0, len(matchList))
+	for _, match := range matchList {
+		start, err := parseTrimFrame(match[1])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseTrimFrame(match[2])
+		if err != nil {
+			return nil, err
+		}
+		trimList = append(trimList, Trim{Start: start, End: end})
+	}
+
+	return trimList, nil
+}
+
+func parseTrimFrame(s string) (int, error) {
+	if s == "None" {
+		return 0, nil
+	}
+	frame, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.New("invalid trim frame: " + s)
+	}
+	return frame, nil
+}
+
+func FormatTrimList(trimList []Trim) string {
+	rangeList := make([]string, 0, len(trimList))
+	for _, trim := range trimList {
+		end := "None"
+		if trim.End != 0 {
+			end = strconv.Itoa(trim.End)
+		}
+		rangeList = append(rangeList, fmt.Sprintf("(%d, %s)", trim.Start, end))
+	}
+	return "[" + strings.Join(rangeList, ", ") + "]"
+}
+
+func (t Trim) Resolve(totalFrameNum int) (int, int, error) {
+	start := t.Start
+	if start < 0 {
+		start += totalFrameNum
+	}
+
+	end := t.End
+	if end == 0 {
+		end = totalFrameNum
+	} else if end < 0 {
+		end += totalFrameNum
+	}
+
+	if start < 0 || end > totalFrameNum || start >= end {
+		return 0, 0, fmt.Errorf("trim range %d:%d out of %d frames", t.Start, t.End, totalFrameNum)
+	}
+
+	return start, end, nil
+}
+
+var fpsRegexp = regexp.MustCompile(`^\(?\s*(\d+)\s*(?:[,/]\s*(\d+)\s*)?\)?$`)
+
+func ParseFPS(s string) (uint, uint, error) {
+	match := fpsRegexp.FindStringSubmatch(strings.TrimSpace(s))
+	if len(match) != 3 {
+		return 0, 0, errors.New("invalid frame rate: " + strings.TrimSpace(s))
+	}
+
+	fpsNum, _ := strconv.ParseUint(match[1], 10, 32)
+	fpsDen := uint64(1)
+	if match[2] != "" {
+		fpsDen, _ = strconv.ParseUint(match[2], 10, 32)
+	}
+	if fpsNum == 0 || fpsDen == 0 {
+		return 0, 0, errors.New("invalid frame rate: " + strings.TrimSpace(s))
+	}
+
+	return uint(fpsNum), uint(fpsDen), nil
+}
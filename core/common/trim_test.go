@@ -0,0 +1,140 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTrimList(t *testing.T) {
+	testList := []struct {
+		s       string
+		expect  []Trim
+		isError bool
+	}{
+		{s: "trim = [(0, 100)]", expect: []Trim{{0, 100}}},
+		{s: "[(10, 20), (30,None)]", expect: []Trim{{10, 20}, {30, 0}}},
+		{s: "[( -100 , -10 ), (None, None)]", expect: []Trim{{-100, -10}, {0, 0}}},
+		{s: "trim = []", isError: true},
+		{s: "trim = [(a, b)]", isError: true},
+	}
+
+	for _, test := range testList {
+		trimList, err := ParseTrimList(test.s)
+		if test.isError {
+			if err == nil {
+				t.Errorf("%q: expect an error", test.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", test.s, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(trimList, test.expect) {
+			t.Errorf("%q: got %v, expect %v", test.s, trimList, test.expect)
+		}
+	}
+}
+
+func TestFormatTrimList(t *testing.T) {
+	trimList := []Trim{{10, 20}, {-100, 0}}
+	s := FormatTrimList(trimList)
+	if s != "[(10, 20), (-100, None)]" {
+		t.Errorf("got %s", s)
+	}
+
+	parsedList, err := ParseTrimList(s)
+	if err != nil || !reflect.DeepEqual(parsedList, trimList) {
+		t.Errorf("round trip: got %v %v", parsedList, err)
+	}
+}
+
+func TestTrimResolve(t *testing.T) {
+	testList := []struct {
+		trim    Trim
+		start   int
+		end     int
+		isError bool
+	}{
+		{trim: Trim{0, 0}, start: 0, end: 1000},
+		{trim: Trim{10, 20}, start: 10, end: 20},
+		{trim: Trim{-100, 0}, start: 900, end: 1000},
+		{trim: Trim{0, -10}, start: 0, end: 990},
+		{trim: Trim{-20, -10}, start: 980, end: 990},
+		{trim: Trim{0, 1000}, start: 0, end: 1000},
+		{trim: Trim{0, 1001}, isError: true},
+		{trim: Trim{-1001, 0}, isError: true},
+		{trim: Trim{20, 10}, isError: true},
+		{trim: Trim{10, 10}, isError: true},
+		{trim: Trim{0, -1000}, isError: true},
+	}
+
+	for _, test := range testList {
+		start, end, err := test.trim.Resolve(1000)
+		if test.isError {
+			if err == nil {
+				t.Errorf("%v: expect an error", test.trim)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %s", test.trim, err.Error())
+			continue
+		}
+		if start != test.start || end != test.end {
+			t.Errorf("%v: got %d:%d, expect %d:%d", test.trim, start, end, test.start, test.end)
+		}
+	}
+}
+
+func TestParseFPS(t *testing.T) {
+	testList := []struct {
+		s       string
+		fpsNum  uint
+		fpsDen  uint
+		isError bool
+	}{
+		{s: "(25, 1)", fpsNum: 25, fpsDen: 1},
+		{s: "24000/1001", fpsNum: 24000, fpsDen: 1001},
+		{s: " 25 ", fpsNum: 25, fpsDen: 1},
+		{s: "(30000,1001)", fpsNum: 30000, fpsDen: 1001},
+		{s: "23.976", isError: true},
+		{s: "25/0", isError: true},
+		{s: "0", isError: true},
+	}
+
+	for _, test := range testList {
+		fpsNum, fpsDen, err := ParseFPS(test.s)
+		if test.isError {
+			if err == nil {
+				t.Errorf("%q: expect an error", test.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", test.s, err.Error())
+			continue
+		}
+		if fpsNum != test.fpsNum || fpsDen != test.fpsDen {
+			t.Errorf("%q: got %d/%d, expect %d/%d", test.s, fpsNum, fpsDen, test.fpsNum, test.fpsDen)
+		}
+	}
+}
@@ -34,6 +34,14 @@ func MoveFile(ctx context.Context, srcPath string, dstPath string) error {
 	return nil
 }
 
+func ReplaceFile(ctx context.Context, srcPath string, dstPath string) error {
+	err := exec.CommandContext(ctx, "cmd", "/C", "move", "/Y", srcPath, dstPath).Run()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 func DeleteFile(ctx context.Context, srcPath string) error {
 	err := exec.CommandContext(ctx, "cmd", "/C", "del", srcPath).Run()
 	if err != nil {
@@ -42,9 +50,14 @@ func DeleteFile(ctx context.Context, srcPath string) error {
 	return nil
 }
 
-func GenerateNewFilePath(srcPath string, targetDirPath string, ext string, lang string, track uint) string {
+func GenerateFileNamePrefix(srcPath string) string {
 	newFileName := strings.Replace(srcPath, "\\", "_", -1)
 	newFileName = strings.Replace(newFileName, ":", "_", -1)
+	return newFileName
+}
+
+func GenerateNewFilePath(srcPath string, targetDirPath string, ext string, lang string, track uint) string {
+	newFileName := GenerateFileNamePrefix(srcPath)
 
 	if track > 0 {
 		newFileName = newFileName + "." + fmt.Sprintf("track%d", track)
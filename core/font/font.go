@@ -0,0 +1,203 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package font
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+var mimeTypeMap = map[string]string{
+	".ttf": "font/ttf",
+	".otf": "font/otf",
+	".ttc": "font/collection",
+	".otc": "font/collection",
+}
+
+func IsFontFile(path string) bool {
+	_, exist := mimeTypeMap[strings.ToLower(filepath.Ext(path))]
+	return exist
+}
+
+func MimeType(path string) string {
+	return mimeTypeMap[strings.ToLower(filepath.Ext(path))]
+}
+
+func ListFontFiles(dirPath string) ([]string, error) {
+	fontList := make([]string, 0)
+	err := filepath.Walk(dirPath, func(path string, fileInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fileInfo.IsDir() && IsFontFile(path) {
+			fontList = append(fontList, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.New("failed to list fonts: " + err.Error())
+	}
+	return fontList, nil
+}
+
+func ReadNames(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("failed to read font file: " + err.Error())
+	}
+
+	if len(data) < 12 {
+		return nil, errors.New("invalid font file: " + path)
+	}
+
+	offsetList := []uint32{0}
+	if string(data[:4]) == "ttcf" {
+		fontNum := binary.BigEndian.Uint32(data[8:12])
+		if uint64(len(data)) < 12+uint64(fontNum)*4 {
+			return nil, errors.New("invalid font collection: " + path)
+		}
+		offsetList = make([]uint32, 0, fontNum)
+		for i := uint32(0); i < fontNum; i++ {
+			offsetList = append(offsetList, binary.BigEndian.Uint32(data[12+i*4:]))
+		}
+	}
+
+	nameList := make([]string, 0)
+	for _, offset := range offsetList {
+		fontNameList, err := readFontNames(data, offset)
+		if err != nil {
+			return nil, errors.New(err.Error() + ": " + path)
+		}
+		nameList = append(nameList, fontNameList...)
+	}
+
+	return nameList, nil
+}
+
+func readFontNames(data []byte, offset uint32) ([]string, error) {
+	if uint64(offset)+12 > uint64(len(data)) {
+		return nil, errors.New("invalid font offset table")
+	}
+
+	tableNum := uint32(binary.BigEndian.Uint16(data[offset+4:]))
+	if uint64(offset)+12+uint64(tableNum)*16 > uint64(len(data)) {
+		return nil, errors.New("invalid font table directory")
+	}
+
+	for i := uint32(0); i < tableNum; i++ {
+		record := data[offset+12+i*16:]
+		if string(record[:4]) != "name" {
+			continue
+		}
+
+		tableOffset := binary.BigEndian.Uint32(record[8:])
+		tableLength := binary.BigEndian.Uint32(record[12:])
+		if uint64(tableOffset)+uint64(tableLength) > uint64(len(data)) {
+			return nil, errors.New("invalid font name table")
+		}
+		return parseNameTable(data[tableOffset : tableOffset+tableLength])
+	}
+
+	return nil, errors.New("font name table not found")
+}
+
+func parseNameTable(table []byte) ([]string, error) {
+	if len(table) < 6 {
+		return nil, errors.New("invalid font name table")
+	}
+
+	recordNum := int(binary.BigEndian.Uint16(table[2:]))
+	stringOffset := int(binary.BigEndian.Uint16(table[4:]))
+	if 6+recordNum*12 > len(table) {
+		return nil, errors.New("invalid font name table")
+	}
+
+	nameSet := make(map[string]bool)
+	nameList := make([]string, 0)
+	for i := 0; i < recordNum; i++ {
+		record := table[6+i*12:]
+		platformId := binary.BigEndian.Uint16(record[0:])
+		encodingId := binary.BigEndian.Uint16(record[2:])
+		nameId := binary.BigEndian.Uint16(record[6:])
+		length := int(binary.BigEndian.Uint16(record[8:]))
+		start := stringOffset + int(binary.BigEndian.Uint16(record[10:]))
+
+		if nameId != 1 && nameId != 4 && nameId != 6 && nameId != 16 {
+			continue
+		}
+		if start+length > len(table) {
+			continue
+		}
+
+		var name string
+		raw := table[start : start+length]
+		switch {
+		case platformId == 0 || platformId == 3:
+			unitList := make([]uint16, 0, len(raw)/2)
+			for j := 0; j+1 < len(raw); j += 2 {
+				unitList = append(unitList, binary.BigEndian.Uint16(raw[j:]))
+			}
+			name = string(utf16.Decode(unitList))
+		case platformId == 1 && encodingId == 0:
+			name = string(raw)
+		default:
+			continue
+		}
+
+		name = strings.TrimSpace(name)
+		if name != "" && !nameSet[strings.ToLower(name)] {
+			nameSet[strings.ToLower(name)] = true
+			nameList = append(nameList, name)
+		}
+	}
+
+	return nameList, nil
+}
+
+func BuildIndex(dirPath string) (map[string]string, error) {
+	fontList, err := ListFontFiles(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]string)
+	for _, path := range fontList {
+		nameList, err := ReadNames(path)
+		if err != nil {
+			continue
+		}
+		for _, name := range nameList {
+			key := strings.ToLower(name)
+			if _, exist := index[key]; !exist {
+				index[key] = path
+			}
+		}
+		baseName := strings.ToLower(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+		if _, exist := index[baseName]; !exist {
+			index[baseName] = path
+		}
+	}
+
+	return index, nil
+}
@@ -0,0 +1,62 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+type Message struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+var webhookUrl string
+
+func SetWebhook(url string) {
+	webhookUrl = url
+}
+
+func Send(title string, body string) {
+	log.Printf("[notify] %s: %s\n", title, body)
+
+	if webhookUrl == "" {
+		return
+	}
+
+	data, err := json.Marshal(Message{Title: title, Body: body})
+	if err != nil {
+		log.Printf("[error] failed to marshal notification: %s\n", err.Error())
+		return
+	}
+
+	go func(url string) {
+		client := http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+		if err != nil {
+			log.Printf("[error] failed to send notification: %s\n", err.Error())
+			return
+		}
+		_ = resp.Body.Close()
+	}(webhookUrl)
+}
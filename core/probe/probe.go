@@ -0,0 +1,299 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package probe
+
+import (
+	"MonitorEncoder/core/common"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var languageCodeMap = map[string]string{
+	"japanese":   "jpn",
+	"english":    "eng",
+	"chinese":    "chi",
+	"korean":     "kor",
+	"french":     "fre",
+	"german":     "ger",
+	"spanish":    "spa",
+	"italian":    "ita",
+	"russian":    "rus",
+	"portuguese": "por",
+	"dutch":      "dut",
+	"swedish":    "swe",
+	"danish":     "dan",
+	"norwegian":  "nor",
+	"finnish":    "fin",
+	"polish":     "pol",
+	"czech":      "cze",
+	"hungarian":  "hun",
+	"greek":      "gre",
+	"turkish":    "tur",
+	"thai":       "tha",
+	"arabic":     "ara",
+	"hebrew":     "heb",
+	"hindi":      "hin",
+	"indonesian": "ind",
+	"malay":      "may",
+	"vietnamese": "vie",
+	"cantonese":  "yue",
+	"mandarin":   "cmn",
+}
+
+var codecPatternList = []struct {
+	pattern string
+	codec   string
+}{
+	{"h264", "h264"},
+	{"avc", "h264"},
+	{"h265", "hevc"},
+	{"hevc", "hevc"},
+	{"mpeg2", "mpeg2"},
+	{"vc-1", "vc1"},
+	{"truehd", "truehd"},
+	{"e-ac3", "eac3"},
+	{"eac3", "eac3"},
+	{"ac3", "ac3"},
+	{"dts master audio", "dtsma"},
+	{"dts hi-res", "dtshr"},
+	{"dts express", "dtsexpress"},
+	{"dts", "dts"},
+	{"pcm", "pcm"},
+	{"flac", "flac"},
+	{"aac", "aac"},
+	{"mp3", "mp3"},
+	{"mp2", "mp2"},
+	{"opus", "opus"},
+	{"pgs", "pgs"},
+	{"vobsub", "vobsub"},
+	{"srt", "srt"},
+	{"ass", "ass"},
+	{"ssa", "ass"},
+}
+
+var (
+	trackLineRegexp  = regexp.MustCompile(`^(\d+): (.+)$`)
+	durationRegexp   = regexp.MustCompile(`^(\d+):(\d{2}):(\d{2})$`)
+	trackRateRegexp  = regexp.MustCompile(`\d+([pi])(\d+(?:\.\d+)?)\s*(/1\.001)?`)
+	headerRateRegexp = regexp.MustCompile(`^(\d+(?:\.\d+)?)([pi])\s*(/1\.001)?$`)
+	channelsRegexp   = regexp.MustCompile(`^(\d\.\d) channels$`)
+	bitrateRegexp    = regexp.MustCompile(`^(\d+)\s*kbps$`)
+	sampleRateRegexp = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*kHz$`)
+	delayRegexp      = regexp.MustCompile(`^([+-]?\d+)ms$`)
+)
+
+func Probe(ctx context.Context, src string) (*common.SourceInfo, error) {
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return nil, errors.New("src file not exist: " + src)
+	}
+
+	eac3toPath := common.GetEac3toPath()
+	eac3toProcess := exec.CommandContext(ctx, eac3toPath, src, "-log=NUL")
+	data, err := eac3toProcess.Output()
+	if err != nil {
+		return nil, errors.New("failed to probe src: " + err.Error())
+	}
+
+	sourceInfo, err := ParseEac3toOutput(string(data))
+	if err != nil {
+		return nil, err
+	}
+	sourceInfo.Src = src
+
+	return sourceInfo, nil
+}
+
+func ParseEac3toOutput(output string) (*common.SourceInfo, error) {
+	sourceInfo := common.SourceInfo{
+		TrackList: make([]common.TrackInfo, 0),
+	}
+
+	headerFound := false
+	for _, line := range strings.Split(output, "\n") {
+		line = cleanLine(line)
+		if line == "" {
+			continue
+		}
+
+		trackMatch := trackLineRegexp.FindStringSubmatch(line)
+		if len(trackMatch) == 3 {
+			track, err := strconv.ParseUint(trackMatch[1], 10, 32)
+			if err != nil {
+				continue
+			}
+			trackInfo := parseTrack(uint(track), trackMatch[2])
+			if trackInfo.Type == common.TrackVideo && sourceInfo.FPSNum == 0 {
+				if match := trackRateRegexp.FindStringSubmatch(trackMatch[2]); len(match) == 4 {
+					sourceInfo.FPSNum, sourceInfo.FPSDen = parseFrameRate(match[2], match[1], match[3])
+				}
+			}
+			sourceInfo.TrackList = append(sourceInfo.TrackList, trackInfo)
+			continue
+		}
+
+		if !headerFound && strings.Contains(line, ", ") && len(sourceInfo.TrackList) == 0 {
+			headerFound = parseHeader(line, &sourceInfo)
+		}
+	}
+
+	if len(sourceInfo.TrackList) <= 0 {
+		return nil, errors.New("no track found in eac3to output")
+	}
+
+	return &sourceInfo, nil
+}
+
+func parseHeader(line string, sourceInfo *common.SourceInfo) bool {
+	fieldList := strings.Split(line, ", ")
+	found := false
+	for i, field := range fieldList {
+		field = strings.TrimSpace(field)
+		if i == 0 && !strings.Contains(field, " ") {
+			sourceInfo.Container = field
+			continue
+		}
+
+		durationMatch := durationRegexp.FindStringSubmatch(field)
+		if len(durationMatch) == 4 {
+			hour, _ := strconv.ParseInt(durationMatch[1], 10, 64)
+			minute, _ := strconv.ParseInt(durationMatch[2], 10, 64)
+			second, _ := strconv.ParseInt(durationMatch[3], 10, 64)
+			sourceInfo.DurationMs = ((hour*60+minute)*60 + second) * 1000
+			found = true
+			continue
+		}
+
+		if match := headerRateRegexp.FindStringSubmatch(field); len(match) == 4 {
+			sourceInfo.FPSNum, sourceInfo.FPSDen = parseFrameRate(match[1], match[2], match[3])
+		}
+	}
+	return found
+}
+
+func parseTrack(track uint, desc string) common.TrackInfo {
+	trackInfo := common.TrackInfo{
+		Track:       track,
+		Description: desc,
+	}
+
+	fieldList := strings.Split(desc, ", ")
+	codecDesc := strings.ToLower(fieldList[0])
+	trackInfo.Codec = normalizeCodec(codecDesc)
+
+	switch {
+	case strings.HasPrefix(codecDesc, "chapters"):
+		trackInfo.Type = common.TrackChapters
+		trackInfo.Codec = "chapters"
+	case strings.HasPrefix(codecDesc, "subtitle"):
+		trackInfo.Type = common.TrackSubtitle
+	case isVideoCodec(trackInfo.Codec):
+		trackInfo.Type = common.TrackVideo
+	default:
+		trackInfo.Type = common.TrackAudio
+	}
+
+	for _, field := range fieldList[1:] {
+		field = strings.TrimSpace(field)
+
+		if code, exist := languageCodeMap[strings.ToLower(field)]; exist {
+			trackInfo.Language = code
+			continue
+		}
+
+		if match := channelsRegexp.FindStringSubmatch(field); len(match) == 2 {
+			trackInfo.Channels = match[1]
+			continue
+		}
+
+		if match := bitrateRegexp.FindStringSubmatch(field); len(match) == 2 {
+			bitrate, _ := strconv.ParseUint(match[1], 10, 32)
+			trackInfo.Bitrate = uint(bitrate)
+			continue
+		}
+
+		if match := sampleRateRegexp.FindStringSubmatch(field); len(match) == 2 {
+			sampleRate, _ := strconv.ParseFloat(match[1], 64)
+			trackInfo.SampleRate = uint(sampleRate * 1000)
+			continue
+		}
+
+		if match := delayRegexp.FindStringSubmatch(field); len(match) == 2 {
+			delay, _ := strconv.ParseInt(match[1], 10, 32)
+			trackInfo.Delay = int(delay)
+			continue
+		}
+	}
+
+	return trackInfo
+}
+
+func normalizeCodec(codecDesc string) string {
+	for _, codecPattern := range codecPatternList {
+		if strings.Contains(codecDesc, codecPattern.pattern) {
+			return codecPattern.codec
+		}
+	}
+	return codecDesc
+}
+
+func isVideoCodec(codec string) bool {
+	switch codec {
+	case "h264", "hevc", "mpeg2", "vc1":
+		return true
+	}
+	return false
+}
+
+func parseFrameRate(rateStr string, scanType string, ntscStr string) (uint, uint) {
+	rate, err := strconv.ParseFloat(rateStr, 64)
+	if err != nil || rate <= 0 {
+		return 0, 0
+	}
+	if scanType == "i" {
+		rate /= 2
+	}
+
+	fpsNum := uint(rate*1000 + 0.5)
+	fpsDen := uint(1000)
+	if ntscStr != "" {
+		fpsDen = 1001
+	} else if fpsNum%1000 == 0 {
+		fpsNum /= 1000
+		fpsDen = 1
+	}
+	return fpsNum, fpsDen
+}
+
+func cleanLine(line string) string {
+	if i := strings.LastIndex(line, "\b"); i >= 0 {
+		line = line[i+1:]
+	}
+	line = strings.Map(func(r rune) rune {
+		if r < 0x20 && r != '\t' {
+			return -1
+		}
+		return r
+	}, line)
+	return strings.TrimSpace(line)
+}
@@ -0,0 +1,153 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package probe
+
+import (
+	"MonitorEncoder/core/common"
+	"reflect"
+	"testing"
+)
+
+const testM2tsOutput = `M2TS, 1 video track, 4 audio tracks, 1 subtitle track, 0:23:40, 24p /1.001
+1: Chapters, 12 chapters
+2: h264/AVC, 1080p24 /1.001 (16:9)
+3: RAW/PCM, Japanese, 2.0 channels, 16 bits, 48kHz
+4: TrueHD/AC3 (Atmos), English, 7.1 channels, 48kHz, +5ms
+5: DTS Master Audio, Japanese, 5.1 channels, 24 bits, 48kHz
+6: AC3, English, 2.0 channels, 192kbps, 48kHz, -20ms
+7: Subtitle (PGS), Japanese
+`
+
+func TestParseEac3toOutput(t *testing.T) {
+	testList := []struct {
+		name      string
+		output    string
+		container string
+		duration  int64
+		fpsNum    uint
+		fpsDen    uint
+		trackList []common.TrackInfo
+	}{
+		{
+			name:      "m2ts",
+			output:    testM2tsOutput,
+			container: "M2TS",
+			duration:  1420000,
+			fpsNum:    24000,
+			fpsDen:    1001,
+			trackList: []common.TrackInfo{
+				{Track: 1, Type: common.TrackChapters, Codec: "chapters"},
+				{Track: 2, Type: common.TrackVideo, Codec: "h264"},
+				{Track: 3, Type: common.TrackAudio, Codec: "pcm", Language: "jpn", Channels: "2.0", SampleRate: 48000},
+				{Track: 4, Type: common.TrackAudio, Codec: "truehd", Language: "eng", Channels: "7.1", SampleRate: 48000, Delay: 5},
+				{Track: 5, Type: common.TrackAudio, Codec: "dtsma", Language: "jpn", Channels: "5.1", SampleRate: 48000},
+				{Track: 6, Type: common.TrackAudio, Codec: "ac3", Language: "eng", Channels: "2.0", Bitrate: 192, SampleRate: 48000, Delay: -20},
+				{Track: 7, Type: common.TrackSubtitle, Codec: "pgs", Language: "jpn"},
+			},
+		},
+		{
+			name:      "progress output and interlaced video",
+			output:    "\b\b\b\bM2TS, 1 video track, 1 audio track, 0:01:05, 60i /1.001\r\n\b\b1: h264/AVC, 1080i60 /1.001 (16:9)\r\n2: E-AC3, German, 5.1 channels, 640kbps, 48kHz\r\n",
+			container: "M2TS",
+			duration:  65000,
+			fpsNum:    30000,
+			fpsDen:    1001,
+			trackList: []common.TrackInfo{
+				{Track: 1, Type: common.TrackVideo, Codec: "h264"},
+				{Track: 2, Type: common.TrackAudio, Codec: "eac3", Language: "ger", Channels: "5.1", Bitrate: 640, SampleRate: 48000},
+			},
+		},
+		{
+			name:      "frame rate from the header",
+			output:    "MKV, 1 video track, 1 subtitle track, 1:00:00, 25p\n1: h265/HEVC, 2160p (16:9)\n2: Subtitle (ASS), English\n",
+			container: "MKV",
+			duration:  3600000,
+			fpsNum:    25,
+			fpsDen:    1,
+			trackList: []common.TrackInfo{
+				{Track: 1, Type: common.TrackVideo, Codec: "hevc"},
+				{Track: 2, Type: common.TrackSubtitle, Codec: "ass", Language: "eng"},
+			},
+		},
+	}
+
+	for _, test := range testList {
+		sourceInfo, err := ParseEac3toOutput(test.output)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+			continue
+		}
+
+		if sourceInfo.Container != test.container || sourceInfo.DurationMs != test.duration {
+			t.Errorf("%s: got container %q duration %d, expect %q %d", test.name, sourceInfo.Container, sourceInfo.DurationMs, test.container, test.duration)
+		}
+		if sourceInfo.FPSNum != test.fpsNum || sourceInfo.FPSDen != test.fpsDen {
+			t.Errorf("%s: got fps %d/%d, expect %d/%d", test.name, sourceInfo.FPSNum, sourceInfo.FPSDen, test.fpsNum, test.fpsDen)
+		}
+		if len(sourceInfo.TrackList) != len(test.trackList) {
+			t.Errorf("%s: got %d tracks, expect %d", test.name, len(sourceInfo.TrackList), len(test.trackList))
+			continue
+		}
+		for i, trackInfo := range sourceInfo.TrackList {
+			trackInfo.Description = ""
+			if !reflect.DeepEqual(trackInfo, test.trackList[i]) {
+				t.Errorf("%s: got track %+v, expect %+v", test.name, trackInfo, test.trackList[i])
+			}
+		}
+	}
+}
+
+func TestParseEac3toOutputError(t *testing.T) {
+	outputList := []string{
+		"",
+		"The source file format is unknown.\n",
+		"M2TS, 0:00:10\n",
+	}
+
+	for _, output := range outputList {
+		_, err := ParseEac3toOutput(output)
+		if err == nil {
+			t.Errorf("%q: expect an error", output)
+		}
+	}
+}
+
+func TestParseFrameRate(t *testing.T) {
+	testList := []struct {
+		rate     string
+		scanType string
+		ntsc     string
+		fpsNum   uint
+		fpsDen   uint
+	}{
+		{"24", "p", "/1.001", 24000, 1001},
+		{"24", "p", "", 24, 1},
+		{"50", "i", "", 25, 1},
+		{"60", "i", "/1.001", 30000, 1001},
+		{"23.976", "p", "", 23976, 1000},
+		{"0", "p", "", 0, 0},
+	}
+
+	for _, test := range testList {
+		fpsNum, fpsDen := parseFrameRate(test.rate, test.scanType, test.ntsc)
+		if fpsNum != test.fpsNum || fpsDen != test.fpsDen {
+			t.Errorf("%s%s%s: got %d/%d, expect %d/%d", test.rate, test.scanType, test.ntsc, fpsNum, fpsDen, test.fpsNum, test.fpsDen)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package report
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+type srcKey struct{}
+
+type Stage struct {
+	Name    string    `json:"name"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Seconds float64   `json:"seconds"`
+}
+
+type Command struct {
+	Path string   `json:"path"`
+	Args []string `json:"args"`
+}
+
+type Record struct {
+	CommandList []Command `json:"commands"`
+	StageList   []Stage   `json:"stages"`
+}
+
+var (
+	recordMap  = make(map[string]*Record)
+	recordLock sync.Mutex
+)
+
+func WithSrc(ctx context.Context, srcFile string) context.Context {
+	return context.WithValue(ctx, srcKey{}, srcFile)
+}
+
+func NewCommand(ctx context.Context, path string, arg ...string) *exec.Cmd {
+	if srcFile, ok := ctx.Value(srcKey{}).(string); ok {
+		AddCommand(srcFile, path, arg)
+	}
+	return exec.CommandContext(ctx, path, arg...)
+}
+
+func AddCommand(srcFile string, path string, argList []string) {
+	recordLock.Lock()
+	defer recordLock.Unlock()
+
+	record := getRecord(srcFile)
+	record.CommandList = append(record.CommandList, Command{Path: path, Args: append([]string(nil), argList...)})
+}
+
+func StartStage(srcFile string, name string) {
+	recordLock.Lock()
+	defer recordLock.Unlock()
+
+	record := getRecord(srcFile)
+	record.StageList = append(record.StageList, Stage{Name: name, Start: time.Now()})
+}
+
+func FinishStage(srcFile string, name string) {
+	recordLock.Lock()
+	defer recordLock.Unlock()
+
+	record := getRecord(srcFile)
+	for i := len(record.StageList) - 1; i >= 0; i-- {
+		stage := &record.StageList[i]
+		if stage.Name == name && stage.End.IsZero() {
+			stage.End = time.Now()
+			stage.Seconds = stage.End.Sub(stage.Start).Seconds()
+			return
+		}
+	}
+}
+
+func Get(srcFile string) Record {
+	recordLock.Lock()
+	defer recordLock.Unlock()
+
+	record := getRecord(srcFile)
+	return Record{
+		CommandList: append([]Command(nil), record.CommandList...),
+		StageList:   append([]Stage(nil), record.StageList...),
+	}
+}
+
+func Reset(srcFile string) {
+	recordLock.Lock()
+	defer recordLock.Unlock()
+
+	recordMap[srcFile] = &Record{}
+}
+
+func Release(srcFile string) {
+	recordLock.Lock()
+	defer recordLock.Unlock()
+
+	delete(recordMap, srcFile)
+}
+
+func (r Record) StageSeconds(name string) float64 {
+	seconds := 0.0
+	for _, stage := range r.StageList {
+		if stage.Name == name {
+			seconds += stage.Seconds
+		}
+	}
+	return seconds
+}
+
+func (c Command) String() string {
+	partList := make([]string, 0, len(c.Args)+1)
+	for _, part := range append([]string{c.Path}, c.Args...) {
+		if part == "" || strings.ContainsAny(part, " \t\"") {
+			part = "\"" + strings.Replace(part, "\"", "\\\"", -1) + "\""
+		}
+		partList = append(partList, part)
+	}
+	return strings.Join(partList, " ")
+}
+
+func getRecord(srcFile string) *Record {
+	record, exist := recordMap[srcFile]
+	if !exist {
+		record = &Record{}
+		recordMap[srcFile] = record
+	}
+	return record
+}
@@ -19,10 +19,12 @@
 package status
 
 import (
+	"MonitorEncoder/core/notify"
 	"encoding/json"
 	"fmt"
 	"sort"
 	"sync"
+	"time"
 )
 
 type Code int
@@ -40,8 +42,23 @@ const (
 type Status struct {
 	Id      uint64
 	SrcFile string
+	Batch   string
 	Code    Code
 	Desc    string
+	Report  []string
+
+	notified     bool
+	errorDescSet bool
+	finishedAt   time.Time
+}
+
+type BatchStatus struct {
+	Batch string
+	Total int
+	Done  int
+	Error int
+
+	firstId uint64
 }
 
 var (
@@ -50,6 +67,8 @@ var (
 	taskId     uint64 = 0
 )
 
+const finishedTTL = 24 * time.Hour
+
 func newStatus(srcFile string) *Status {
 	taskId += 1
 
@@ -65,11 +84,90 @@ func SetStatusCode(srcFile string, code Code) {
 	statusLock.Lock()
 	defer statusLock.Unlock()
 
+	pruneFinished(time.Now())
+
 	_, exist := statusMap[srcFile]
 	if !exist {
 		statusMap[srcFile] = newStatus(srcFile)
 	}
+	if isFailed(statusMap[srcFile]) && code != ERROR && code != WAIT {
+		return
+	}
+	if code != ERROR {
+		statusMap[srcFile].errorDescSet = false
+	}
 	statusMap[srcFile].Code = code
+
+	if code == WAIT {
+		statusMap[srcFile].Report = nil
+	}
+
+	if code != DONE && code != ERROR {
+		statusMap[srcFile].notified = false
+		statusMap[srcFile].finishedAt = time.Time{}
+		return
+	}
+
+	if statusMap[srcFile].notified {
+		return
+	}
+	statusMap[srcFile].notified = true
+	statusMap[srcFile].finishedAt = time.Now()
+
+	batch := statusMap[srcFile].Batch
+	if batch == "" {
+		if code == DONE {
+			notify.Send("task finished", srcFile)
+		} else {
+			notify.Send("task failed", srcFile)
+		}
+		return
+	}
+
+	batchStatus := getBatchStatus(batch)
+	if batchStatus.Done+batchStatus.Error == batchStatus.Total {
+		notify.Send("batch finished", fmt.Sprintf("%s: %d/%d done, %d failed", batch, batchStatus.Done, batchStatus.Total, batchStatus.Error))
+	}
+}
+
+func isFailed(status *Status) bool {
+	return status.Code == ERROR && status.notified
+}
+
+func isExpired(status *Status, now time.Time) bool {
+	return !status.finishedAt.IsZero() && now.Sub(status.finishedAt) > finishedTTL
+}
+
+func pruneFinished(now time.Time) {
+	batchExpiredMap := make(map[string]bool)
+	for _, status := range statusMap {
+		if status.Batch == "" {
+			continue
+		}
+		expired, exist := batchExpiredMap[status.Batch]
+		batchExpiredMap[status.Batch] = (expired || !exist) && isExpired(status, now)
+	}
+
+	for srcFile, status := range statusMap {
+		expired := isExpired(status, now)
+		if status.Batch != "" {
+			expired = batchExpiredMap[status.Batch]
+		}
+		if expired {
+			delete(statusMap, srcFile)
+		}
+	}
+}
+
+func SetStatusBatch(srcFile string, batch string) {
+	statusLock.Lock()
+	defer statusLock.Unlock()
+
+	_, exist := statusMap[srcFile]
+	if !exist {
+		statusMap[srcFile] = newStatus(srcFile)
+	}
+	statusMap[srcFile].Batch = batch
 }
 
 func SetStatusDesc(srcFile string, desc string) {
@@ -80,9 +178,27 @@ func SetStatusDesc(srcFile string, desc string) {
 	if !exist {
 		statusMap[srcFile] = newStatus(srcFile)
 	}
+
+	if isFailed(statusMap[srcFile]) {
+		if statusMap[srcFile].errorDescSet {
+			return
+		}
+		statusMap[srcFile].errorDescSet = true
+	}
 	statusMap[srcFile].Desc = desc
 }
 
+func AddStatusReport(srcFile string, line string) {
+	statusLock.Lock()
+	defer statusLock.Unlock()
+
+	_, exist := statusMap[srcFile]
+	if !exist {
+		statusMap[srcFile] = newStatus(srcFile)
+	}
+	statusMap[srcFile].Report = append(statusMap[srcFile].Report, line)
+}
+
 func PrintAllStatus() {
 	statusLock.Lock()
 	defer statusLock.Unlock()
@@ -90,6 +206,12 @@ func PrintAllStatus() {
 	fmt.Printf("----------------- Status ----------------\n")
 	for srcFile, status := range statusMap {
 		fmt.Printf("%s:\t\t%s\n", srcFile, status.Desc)
+		for _, line := range status.Report {
+			fmt.Printf("\t%s\n", line)
+		}
+	}
+	for _, batchStatus := range getAllBatchStatus() {
+		fmt.Printf("batch %s:\t\t%d/%d done, %d failed\n", batchStatus.Batch, batchStatus.Done, batchStatus.Total, batchStatus.Error)
 	}
 	fmt.Printf("-----------------------------------------\n")
 }
@@ -122,3 +244,56 @@ func GetAllStatusJson() []byte {
 
 	return data
 }
+
+func GetAllBatchStatus() []BatchStatus {
+	statusLock.Lock()
+	defer statusLock.Unlock()
+
+	return getAllBatchStatus()
+}
+
+func getAllBatchStatus() []BatchStatus {
+	batchMap := make(map[string]*BatchStatus)
+	for _, status := range statusMap {
+		if status.Batch == "" {
+			continue
+		}
+		if _, exist := batchMap[status.Batch]; !exist {
+			batchStatus := getBatchStatus(status.Batch)
+			batchMap[status.Batch] = &batchStatus
+		}
+	}
+
+	batchList := make([]BatchStatus, 0, len(batchMap))
+	for _, batchStatus := range batchMap {
+		batchList = append(batchList, *batchStatus)
+	}
+
+	sort.Slice(batchList, func(i int, j int) bool {
+		return batchList[i].firstId < batchList[j].firstId
+	})
+
+	return batchList
+}
+
+func getBatchStatus(batch string) BatchStatus {
+	batchStatus := BatchStatus{Batch: batch}
+	for _, status := range statusMap {
+		if status.Batch != batch {
+			continue
+		}
+
+		batchStatus.Total += 1
+		if status.Code == DONE {
+			batchStatus.Done += 1
+		} else if status.Code == ERROR && status.notified {
+			batchStatus.Error += 1
+		}
+
+		if batchStatus.firstId == 0 || status.Id < batchStatus.firstId {
+			batchStatus.firstId = status.Id
+		}
+	}
+
+	return batchStatus
+}
@@ -0,0 +1,333 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package subtitle
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var defaultAssFormat = []string{"Layer", "Start", "End", "Style", "Name", "MarginL", "MarginR", "MarginV", "Effect", "Text"}
+
+var defaultAssHeader = []string{
+	"[Script Info]",
+	"ScriptType: v4.00+",
+	"PlayResX: 1920",
+	"PlayResY: 1080",
+	"WrapStyle: 0",
+	"ScaledBorderAndShadow: yes",
+	"",
+	"[V4+ Styles]",
+	"Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding",
+	"Style: Default,Arial,60,&H00FFFFFF,&H000000FF,&H00000000,&H80000000,0,0,0,0,100,100,0,0,1,3,1,2,60,60,50,1",
+	"",
+}
+
+var (
+	assTimeRegexp     = regexp.MustCompile(`^(\d+):(\d{2}):(\d{2})\.(\d{1,3})$`)
+	assOverrideRegexp = regexp.MustCompile(`\{[^}]*\}`)
+	srtTagReplacer    = strings.NewReplacer(
+		"<i>", `{\i1}`, "</i>", `{\i0}`,
+		"<b>", `{\b1}`, "</b>", `{\b0}`,
+		"<u>", `{\u1}`, "</u>", `{\u0}`,
+		"\n", `\N`,
+	)
+	srtTagRegexp  = regexp.MustCompile(`</?[a-zA-Z][^>]*>`)
+	assFontRegexp = regexp.MustCompile(`\\fn([^\\}]*)`)
+)
+
+type AssEvent struct {
+	Kind   string
+	Fields []string
+}
+
+type Ass struct {
+	HeaderList  []string
+	Format      []string
+	EventList   []AssEvent
+	TrailerList []string
+}
+
+func ReadAss(path string) (*Ass, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("failed to read ass file: " + err.Error())
+	}
+
+	ass := Ass{
+		HeaderList:  make([]string, 0),
+		Format:      defaultAssFormat,
+		EventList:   make([]AssEvent, 0),
+		TrailerList: make([]string, 0),
+	}
+
+	section := ""
+	eventsDone := false
+	for _, line := range splitLines(data) {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			if section == "[events]" {
+				eventsDone = true
+			}
+			section = strings.ToLower(trimmed)
+		}
+
+		switch {
+		case eventsDone:
+			ass.TrailerList = append(ass.TrailerList, line)
+		case section != "[events]":
+			ass.HeaderList = append(ass.HeaderList, line)
+		case strings.HasPrefix(trimmed, "Format:"):
+			ass.Format = splitAssFields(strings.TrimPrefix(trimmed, "Format:"), -1)
+		case strings.HasPrefix(trimmed, "Dialogue:"), strings.HasPrefix(trimmed, "Comment:"):
+			colon := strings.Index(trimmed, ":")
+			ass.EventList = append(ass.EventList, AssEvent{
+				Kind:   trimmed[:colon],
+				Fields: splitAssFields(trimmed[colon+1:], len(ass.Format)),
+			})
+		}
+	}
+
+	if ass.fieldIndex("Start") < 0 || ass.fieldIndex("End") < 0 || ass.fieldIndex("Text") < 0 {
+		return nil, errors.New("invalid ass event format in " + path)
+	}
+
+	return &ass, nil
+}
+
+func splitAssFields(s string, n int) []string {
+	fieldList := strings.SplitN(strings.TrimSpace(s), ",", n)
+	for i := range fieldList {
+		if n < 0 || i < len(fieldList)-1 {
+			fieldList[i] = strings.TrimSpace(fieldList[i])
+		}
+	}
+	return fieldList
+}
+
+func (a *Ass) fieldIndex(name string) int {
+	for i, field := range a.Format {
+		if strings.EqualFold(field, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (a *Ass) Write(path string) error {
+	var builder strings.Builder
+	for _, line := range trimTrailingEmptyLines(a.HeaderList) {
+		builder.WriteString(line + "\n")
+	}
+
+	builder.WriteString("\n[Events]\n")
+	builder.WriteString("Format: " + strings.Join(a.Format, ", ") + "\n")
+	for _, event := range a.EventList {
+		builder.WriteString(event.Kind + ": " + strings.Join(event.Fields, ",") + "\n")
+	}
+
+	if len(a.TrailerList) > 0 {
+		builder.WriteString("\n")
+		for _, line := range trimTrailingEmptyLines(a.TrailerList) {
+			builder.WriteString(line + "\n")
+		}
+	}
+
+	err := ioutil.WriteFile(path, []byte(builder.String()), 0644)
+	if err != nil {
+		return errors.New("failed to write ass file: " + err.Error())
+	}
+	return nil
+}
+
+func (a *Ass) Retime(retimer *Retimer) {
+	startIndex := a.fieldIndex("Start")
+	endIndex := a.fieldIndex("End")
+
+	eventList := make([]AssEvent, 0, len(a.EventList))
+	for _, event := range a.EventList {
+		if len(event.Fields) != len(a.Format) {
+			continue
+		}
+
+		start, startErr := parseAssTime(event.Fields[startIndex])
+		end, endErr := parseAssTime(event.Fields[endIndex])
+		if startErr != nil || endErr != nil {
+			eventList = append(eventList, event)
+			continue
+		}
+
+		start = retimer.Map(start)
+		end = retimer.Map(end)
+		if end <= start {
+			continue
+		}
+
+		event.Fields = append([]string(nil), event.Fields...)
+		event.Fields[startIndex] = formatAssTime(start)
+		event.Fields[endIndex] = formatAssTime(end)
+		eventList = append(eventList, event)
+	}
+
+	a.EventList = eventList
+}
+
+func (a *Ass) ToCues() []Cue {
+	startIndex := a.fieldIndex("Start")
+	endIndex := a.fieldIndex("End")
+	textIndex := a.fieldIndex("Text")
+
+	cueList := make([]Cue, 0, len(a.EventList))
+	for _, event := range a.EventList {
+		if event.Kind != "Dialogue" || len(event.Fields) != len(a.Format) {
+			continue
+		}
+
+		start, startErr := parseAssTime(event.Fields[startIndex])
+		end, endErr := parseAssTime(event.Fields[endIndex])
+		if startErr != nil || endErr != nil {
+			continue
+		}
+
+		text := assOverrideRegexp.ReplaceAllString(event.Fields[textIndex], "")
+		text = strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ").Replace(text)
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+
+		cueList = append(cueList, Cue{Start: start, End: end, Text: text})
+	}
+
+	return cueList
+}
+
+func (a *Ass) FontNames() []string {
+	fontSet := make(map[string]bool)
+	fontList := make([]string, 0)
+	addFont := func(name string) {
+		name = strings.TrimPrefix(strings.TrimSpace(name), "@")
+		if name == "" || fontSet[strings.ToLower(name)] {
+			return
+		}
+		fontSet[strings.ToLower(name)] = true
+		fontList = append(fontList, name)
+	}
+
+	section := ""
+	fontIndex := 1
+	for _, line := range a.HeaderList {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			section = strings.ToLower(trimmed)
+			continue
+		}
+		if section != "[v4+ styles]" && section != "[v4 styles]" {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "Format:") {
+			for i, field := range splitAssFields(strings.TrimPrefix(trimmed, "Format:"), -1) {
+				if strings.EqualFold(field, "Fontname") {
+					fontIndex = i
+				}
+			}
+		} else if strings.HasPrefix(trimmed, "Style:") {
+			fieldList := splitAssFields(strings.TrimPrefix(trimmed, "Style:"), -1)
+			if fontIndex < len(fieldList) {
+				addFont(fieldList[fontIndex])
+			}
+		}
+	}
+
+	textIndex := a.fieldIndex("Text")
+	for _, event := range a.EventList {
+		if event.Kind != "Dialogue" || textIndex < 0 || textIndex >= len(event.Fields) {
+			continue
+		}
+		for _, override := range assOverrideRegexp.FindAllString(event.Fields[textIndex], -1) {
+			for _, match := range assFontRegexp.FindAllStringSubmatch(override, -1) {
+				addFont(match[1])
+			}
+		}
+	}
+
+	return fontList
+}
+
+func NewAssFromCues(cueList []Cue, stylePath string) (*Ass, error) {
+	ass := Ass{
+		HeaderList:  defaultAssHeader,
+		Format:      defaultAssFormat,
+		EventList:   make([]AssEvent, 0, len(cueList)),
+		TrailerList: make([]string, 0),
+	}
+
+	if stylePath != "" {
+		styleAss, err := ReadAss(stylePath)
+		if err != nil {
+			return nil, err
+		}
+		ass.HeaderList = styleAss.HeaderList
+	}
+
+	styleName := "Default"
+	for _, line := range ass.HeaderList {
+		if strings.HasPrefix(line, "Style:") {
+			styleName = splitAssFields(strings.TrimPrefix(line, "Style:"), 2)[0]
+			break
+		}
+	}
+
+	for _, cue := range cueList {
+		text := srtTagReplacer.Replace(cue.Text)
+		text = srtTagRegexp.ReplaceAllString(text, "")
+		ass.EventList = append(ass.EventList, AssEvent{
+			Kind:   "Dialogue",
+			Fields: []string{"0", formatAssTime(cue.Start), formatAssTime(cue.End), styleName, "", "0", "0", "0", "", text},
+		})
+	}
+
+	return &ass, nil
+}
+
+func parseAssTime(s string) (time.Duration, error) {
+	match := assTimeRegexp.FindStringSubmatch(strings.TrimSpace(s))
+	if len(match) != 5 {
+		return 0, fmt.Errorf("invalid ass time: %s", s)
+	}
+	return parseClock(match[1], match[2], match[3], match[4]), nil
+}
+
+func formatAssTime(t time.Duration) string {
+	cs := (t.Milliseconds() + 5) / 10
+	return fmt.Sprintf("%d:%02d:%02d.%02d", cs/360000, cs/6000%60, cs/100%60, cs%100)
+}
+
+func trimTrailingEmptyLines(lineList []string) []string {
+	end := len(lineList)
+	for end > 0 && strings.TrimSpace(lineList[end-1]) == "" {
+		end -= 1
+	}
+	return lineList[:end]
+}
@@ -0,0 +1,149 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package subtitle
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testAss = `[Script Info]
+ScriptType: v4.00+
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize
+Style: Default,Source Han Sans,60
+Style: Sign,@Noto Sans,40
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+Dialogue: 0,0:00:01.00,0:00:02.50,Default,,0,0,0,,{\fnNoto Sans}hello, world\Nsecond
+Comment: 0,0:00:03.00,0:00:04.00,Default,,0,0,0,,note
+Dialogue: 0,0:00:12.00,0:00:13.00,Sign,,0,0,0,,{\an8}later
+
+[Fonts]
+fontname: embedded.ttf
+`
+
+func writeTestAss(t *testing.T) string {
+	assPath := filepath.Join(t.TempDir(), "test.ass")
+	if err := ioutil.WriteFile(assPath, []byte(testAss), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return assPath
+}
+
+func TestReadAss(t *testing.T) {
+	ass, err := ReadAss(writeTestAss(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ass.EventList) != 3 {
+		t.Fatalf("got %d events, expect 3", len(ass.EventList))
+	}
+	if text := ass.EventList[0].Fields[9]; text != `{\fnNoto Sans}hello, world\Nsecond` {
+		t.Errorf("got text %q", text)
+	}
+	if ass.EventList[1].Kind != "Comment" {
+		t.Errorf("got kind %s, expect Comment", ass.EventList[1].Kind)
+	}
+	if !reflect.DeepEqual(ass.TrailerList, []string{"[Fonts]", "fontname: embedded.ttf", ""}) {
+		t.Errorf("got trailer %q", ass.TrailerList)
+	}
+
+	expectFontList := []string{"Source Han Sans", "Noto Sans"}
+	if fontList := ass.FontNames(); !reflect.DeepEqual(fontList, expectFontList) {
+		t.Errorf("got fonts %q, expect %q", fontList, expectFontList)
+	}
+}
+
+func TestAssRetime(t *testing.T) {
+	ass, err := ReadAss(writeTestAss(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ass.Retime(NewRetimer([]Range{{Start: 2 * time.Second, End: 20 * time.Second}}, 1, 1, 500*time.Millisecond))
+
+	expect := [][2]string{
+		{"0:00:00.50", "0:00:01.00"},
+		{"0:00:01.50", "0:00:02.50"},
+		{"0:00:10.50", "0:00:11.50"},
+	}
+	if len(ass.EventList) != len(expect) {
+		t.Fatalf("got %d events, expect %d", len(ass.EventList), len(expect))
+	}
+	for i, event := range ass.EventList {
+		if event.Fields[1] != expect[i][0] || event.Fields[2] != expect[i][1] {
+			t.Errorf("event #%d: got %s --> %s, expect %s --> %s", i, event.Fields[1], event.Fields[2], expect[i][0], expect[i][1])
+		}
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "retimed.ass")
+	if err = ass.Write(outputPath); err != nil {
+		t.Fatal(err)
+	}
+	retimedAss, err := ReadAss(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(retimedAss.EventList, ass.EventList) {
+		t.Errorf("events changed after write: %+v", retimedAss.EventList)
+	}
+}
+
+func TestAssToCues(t *testing.T) {
+	ass, err := ReadAss(writeTestAss(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []Cue{
+		{Start: 1 * time.Second, End: 2500 * time.Millisecond, Text: "hello, world\nsecond"},
+		{Start: 12 * time.Second, End: 13 * time.Second, Text: "later"},
+	}
+	if cueList := ass.ToCues(); !reflect.DeepEqual(cueList, expect) {
+		t.Errorf("got %+v, expect %+v", cueList, expect)
+	}
+}
+
+func TestNewAssFromCues(t *testing.T) {
+	cueList := []Cue{{Start: 1 * time.Second, End: 2 * time.Second, Text: "<i>a</i>\n<font color=red>b</font>"}}
+
+	ass, err := NewAssFromCues(cueList, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectFields := []string{"0", "0:00:01.00", "0:00:02.00", "Default", "", "0", "0", "0", "", `{\i1}a{\i0}\Nb`}
+	if len(ass.EventList) != 1 || !reflect.DeepEqual(ass.EventList[0].Fields, expectFields) {
+		t.Errorf("got %+v, expect %q", ass.EventList, expectFields)
+	}
+
+	ass, err = NewAssFromCues(cueList, writeTestAss(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if style := ass.EventList[0].Fields[3]; style != "Default" {
+		t.Errorf("got style %s, expect Default", style)
+	}
+}
@@ -0,0 +1,89 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package subtitle
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"time"
+)
+
+const (
+	pgsClockRate    = 90000
+	pgsHeaderSize   = 13
+	pgsSegmentEnd   = 0x80
+	pgsMaxTimestamp = 1<<32 - 1
+)
+
+func RetimeSup(srcPath string, dstPath string, retimer *Retimer) error {
+	data, err := ioutil.ReadFile(srcPath)
+	if err != nil {
+		return errors.New("failed to read sup file: " + err.Error())
+	}
+
+	setStart := 0
+	pos := 0
+	for pos < len(data) {
+		if pos+pgsHeaderSize > len(data) || data[pos] != 'P' || data[pos+1] != 'G' {
+			return errors.New("invalid pgs segment in " + srcPath)
+		}
+		segmentType := data[pos+10]
+		segmentSize := int(binary.BigEndian.Uint16(data[pos+11 : pos+13]))
+		pos += pgsHeaderSize + segmentSize
+		if pos > len(data) {
+			return errors.New("truncated pgs segment in " + srcPath)
+		}
+
+		if segmentType == pgsSegmentEnd {
+			retimeDisplaySet(data[setStart:pos], retimer)
+			setStart = pos
+		}
+	}
+
+	err = ioutil.WriteFile(dstPath, data, 0644)
+	if err != nil {
+		return errors.New("failed to write sup file: " + err.Error())
+	}
+	return nil
+}
+
+func retimeDisplaySet(displaySet []byte, retimer *Retimer) {
+	pts := int64(binary.BigEndian.Uint32(displaySet[2:6]))
+	newPts := retimer.Map(time.Duration(pts)*time.Second/pgsClockRate).Nanoseconds() * pgsClockRate / int64(time.Second)
+	delta := newPts - pts
+
+	for pos := 0; pos+pgsHeaderSize <= len(displaySet); {
+		shiftTimestamp(displaySet[pos+2:pos+6], delta)
+		if binary.BigEndian.Uint32(displaySet[pos+6:pos+10]) != 0 {
+			shiftTimestamp(displaySet[pos+6:pos+10], delta)
+		}
+		pos += pgsHeaderSize + int(binary.BigEndian.Uint16(displaySet[pos+11:pos+13]))
+	}
+}
+
+func shiftTimestamp(b []byte, delta int64) {
+	ts := int64(binary.BigEndian.Uint32(b)) + delta
+	if ts < 0 {
+		ts = 0
+	} else if ts > pgsMaxTimestamp {
+		ts = pgsMaxTimestamp
+	}
+	binary.BigEndian.PutUint32(b, uint32(ts))
+}
@@ -0,0 +1,113 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package subtitle
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func buildPgsSegment(pts uint32, dts uint32, segmentType byte, payload []byte) []byte {
+	segment := make([]byte, pgsHeaderSize, pgsHeaderSize+len(payload))
+	segment[0] = 'P'
+	segment[1] = 'G'
+	binary.BigEndian.PutUint32(segment[2:6], pts)
+	binary.BigEndian.PutUint32(segment[6:10], dts)
+	segment[10] = segmentType
+	binary.BigEndian.PutUint16(segment[11:13], uint16(len(payload)))
+	return append(segment, payload...)
+}
+
+func buildDisplaySet(pts uint32, dts uint32) []byte {
+	displaySet := buildPgsSegment(pts, dts, 0x16, []byte{1, 2, 3})
+	return append(displaySet, buildPgsSegment(pts, 0, pgsSegmentEnd, nil)...)
+}
+
+func readPgsTimestamps(data []byte) [][2]uint32 {
+	timestampList := make([][2]uint32, 0)
+	for pos := 0; pos+pgsHeaderSize <= len(data); {
+		timestampList = append(timestampList, [2]uint32{binary.BigEndian.Uint32(data[pos+2 : pos+6]), binary.BigEndian.Uint32(data[pos+6 : pos+10])})
+		pos += pgsHeaderSize + int(binary.BigEndian.Uint16(data[pos+11:pos+13]))
+	}
+	return timestampList
+}
+
+func TestRetimeSup(t *testing.T) {
+	data := append(buildDisplaySet(90000, 89000), buildDisplaySet(20*90000, 0)...)
+	dirPath := t.TempDir()
+	srcPath := filepath.Join(dirPath, "src.sup")
+	dstPath := filepath.Join(dirPath, "dst.sup")
+	if err := ioutil.WriteFile(srcPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	retimer := NewRetimer([]Range{{Start: 10 * time.Second, End: 30 * time.Second}}, 1, 1, 500*time.Millisecond)
+	if err := RetimeSup(srcPath, dstPath, retimer); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := ioutil.ReadFile(dstPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(output) != len(data) {
+		t.Fatalf("got %d bytes, expect %d", len(output), len(data))
+	}
+
+	expect := [][2]uint32{
+		{45000, 44000},
+		{45000, 0},
+		{10*90000 + 45000, 0},
+		{10*90000 + 45000, 0},
+	}
+	timestampList := readPgsTimestamps(output)
+	if len(timestampList) != len(expect) {
+		t.Fatalf("got %d segments, expect %d", len(timestampList), len(expect))
+	}
+	for i := range expect {
+		if timestampList[i] != expect[i] {
+			t.Errorf("segment #%d: got %v, expect %v", i, timestampList[i], expect[i])
+		}
+	}
+}
+
+func TestRetimeSupInvalid(t *testing.T) {
+	displaySet := buildDisplaySet(90000, 0)
+	testList := []struct {
+		name string
+		data []byte
+	}{
+		{name: "bad magic", data: append([]byte("XX"), displaySet[2:]...)},
+		{name: "truncated segment", data: displaySet[:len(displaySet)-pgsHeaderSize-1]},
+	}
+
+	dirPath := t.TempDir()
+	for _, test := range testList {
+		srcPath := filepath.Join(dirPath, "src.sup")
+		if err := ioutil.WriteFile(srcPath, test.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := RetimeSup(srcPath, filepath.Join(dirPath, "dst.sup"), NewRetimer(nil, 1, 1, 0)); err == nil {
+			t.Errorf("%s: expect an error", test.name)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package subtitle
+
+import "time"
+
+type Range struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+type Retimer struct {
+	rangeList []Range
+	scaleNum  int64
+	scaleDen  int64
+	shift     time.Duration
+}
+
+func NewRetimer(rangeList []Range, scaleNum int64, scaleDen int64, shift time.Duration) *Retimer {
+	if scaleNum <= 0 || scaleDen <= 0 {
+		scaleNum, scaleDen = 1, 1
+	}
+	return &Retimer{
+		rangeList: rangeList,
+		scaleNum:  scaleNum,
+		scaleDen:  scaleDen,
+		shift:     shift,
+	}
+}
+
+func (r *Retimer) IsIdentity() bool {
+	return len(r.rangeList) == 0 && r.scaleNum == r.scaleDen && r.shift == 0
+}
+
+func (r *Retimer) Map(t time.Duration) time.Duration {
+	if len(r.rangeList) > 0 {
+		var offset time.Duration
+		mapped := time.Duration(-1)
+		for _, rg := range r.rangeList {
+			if t < rg.Start {
+				mapped = offset
+				break
+			}
+			if t < rg.End {
+				mapped = offset + t - rg.Start
+				break
+			}
+			offset += rg.End - rg.Start
+		}
+		if mapped < 0 {
+			mapped = offset
+		}
+		t = mapped
+	}
+
+	t = time.Duration(int64(t) * r.scaleNum / r.scaleDen)
+	t += r.shift
+	if t < 0 {
+		t = 0
+	}
+
+	return t
+}
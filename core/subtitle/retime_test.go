@@ -0,0 +1,72 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package subtitle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetimerMap(t *testing.T) {
+	rangeList := []Range{{Start: 1 * time.Second, End: 3 * time.Second}, {Start: 5 * time.Second, End: 6 * time.Second}}
+
+	testList := []struct {
+		name    string
+		retimer *Retimer
+		t       time.Duration
+		expect  time.Duration
+	}{
+		{name: "before the first range", retimer: NewRetimer(rangeList, 1, 1, 0), t: 500 * time.Millisecond, expect: 0},
+		{name: "inside the first range", retimer: NewRetimer(rangeList, 1, 1, 0), t: 2 * time.Second, expect: 1 * time.Second},
+		{name: "between ranges", retimer: NewRetimer(rangeList, 1, 1, 0), t: 4 * time.Second, expect: 2 * time.Second},
+		{name: "inside the second range", retimer: NewRetimer(rangeList, 1, 1, 0), t: 5500 * time.Millisecond, expect: 2500 * time.Millisecond},
+		{name: "after the last range", retimer: NewRetimer(rangeList, 1, 1, 0), t: 10 * time.Second, expect: 3 * time.Second},
+		{name: "scale", retimer: NewRetimer(nil, 1001, 1000, 0), t: 10 * time.Second, expect: 10010 * time.Millisecond},
+		{name: "shift", retimer: NewRetimer(nil, 1, 1, 200*time.Millisecond), t: 1 * time.Second, expect: 1200 * time.Millisecond},
+		{name: "negative shift clamps to zero", retimer: NewRetimer(nil, 1, 1, -2*time.Second), t: 1 * time.Second, expect: 0},
+		{name: "trim then scale then shift", retimer: NewRetimer(rangeList, 2, 1, 100*time.Millisecond), t: 2 * time.Second, expect: 2100 * time.Millisecond},
+		{name: "invalid scale is ignored", retimer: NewRetimer(nil, 0, 1, 0), t: 1 * time.Second, expect: 1 * time.Second},
+	}
+
+	for _, test := range testList {
+		if got := test.retimer.Map(test.t); got != test.expect {
+			t.Errorf("%s: got %s, expect %s", test.name, got, test.expect)
+		}
+	}
+}
+
+func TestRetimerIsIdentity(t *testing.T) {
+	testList := []struct {
+		retimer *Retimer
+		expect  bool
+	}{
+		{retimer: NewRetimer(nil, 1, 1, 0), expect: true},
+		{retimer: NewRetimer(nil, 1001, 1001, 0), expect: true},
+		{retimer: NewRetimer(nil, 0, 0, 0), expect: true},
+		{retimer: NewRetimer([]Range{{Start: 0, End: time.Second}}, 1, 1, 0), expect: false},
+		{retimer: NewRetimer(nil, 1001, 1000, 0), expect: false},
+		{retimer: NewRetimer(nil, 1, 1, time.Millisecond), expect: false},
+	}
+
+	for i, test := range testList {
+		if got := test.retimer.IsIdentity(); got != test.expect {
+			t.Errorf("#%d: got %v, expect %v", i, got, test.expect)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package subtitle
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Cue struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+var srtTimeRegexp = regexp.MustCompile(`(\d+):(\d{2}):(\d{2})[,.](\d{1,3})\s*-->\s*(\d+):(\d{2}):(\d{2})[,.](\d{1,3})`)
+
+func ReadSrt(path string) ([]Cue, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("failed to read srt file: " + err.Error())
+	}
+
+	cueList := make([]Cue, 0)
+	var cue *Cue
+	for _, line := range splitLines(data) {
+		if match := srtTimeRegexp.FindStringSubmatch(line); len(match) == 9 {
+			if cue != nil {
+				cueList = append(cueList, finishCue(cue))
+			}
+			cue = &Cue{
+				Start: parseClock(match[1], match[2], match[3], match[4]),
+				End:   parseClock(match[5], match[6], match[7], match[8]),
+			}
+			continue
+		}
+
+		if cue == nil {
+			continue
+		}
+		cue.Text += line + "\n"
+	}
+	if cue != nil {
+		cueList = append(cueList, finishCue(cue))
+	}
+
+	return cueList, nil
+}
+
+func finishCue(cue *Cue) Cue {
+	lineList := strings.Split(strings.TrimRight(cue.Text, "\n"), "\n")
+	if len(lineList) > 1 {
+		if _, err := strconv.Atoi(strings.TrimSpace(lineList[len(lineList)-1])); err == nil && strings.TrimSpace(lineList[len(lineList)-2]) == "" {
+			lineList = lineList[:len(lineList)-2]
+		}
+	}
+	cue.Text = strings.TrimSpace(strings.Join(lineList, "\n"))
+	return *cue
+}
+
+func WriteSrt(path string, cueList []Cue) error {
+	var builder strings.Builder
+	index := 1
+	for _, cue := range cueList {
+		if cue.End <= cue.Start || cue.Text == "" {
+			continue
+		}
+		builder.WriteString(fmt.Sprintf("%d\n%s --> %s\n%s\n\n", index, formatSrtTime(cue.Start), formatSrtTime(cue.End), cue.Text))
+		index += 1
+	}
+
+	err := ioutil.WriteFile(path, []byte(builder.String()), 0644)
+	if err != nil {
+		return errors.New("failed to write srt file: " + err.Error())
+	}
+	return nil
+}
+
+func RetimeCues(cueList []Cue, retimer *Retimer) []Cue {
+	newCueList := make([]Cue, 0, len(cueList))
+	for _, cue := range cueList {
+		cue.Start = retimer.Map(cue.Start)
+		cue.End = retimer.Map(cue.End)
+		if cue.End > cue.Start {
+			newCueList = append(newCueList, cue)
+		}
+	}
+	return newCueList
+}
+
+func formatSrtTime(t time.Duration) string {
+	ms := t.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+func parseClock(hour string, minute string, second string, fraction string) time.Duration {
+	h, _ := strconv.Atoi(hour)
+	m, _ := strconv.Atoi(minute)
+	s, _ := strconv.Atoi(second)
+	f, _ := strconv.Atoi(fraction)
+	for i := len(fraction); i < 3; i++ {
+		f *= 10
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second + time.Duration(f)*time.Millisecond
+}
+
+func splitLines(data []byte) []string {
+	text := strings.TrimPrefix(string(data), "\ufeff")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.Split(text, "\n")
+}
+
+func IsTextSubtitle(path string) bool {
+	switch strings.ToLower(strings.TrimPrefix(filepath.Ext(path), ".")) {
+	case "srt", "ass", "ssa":
+		return true
+	}
+	return false
+}
+
+func IsSubtitle(path string) bool {
+	return IsTextSubtitle(path) || strings.EqualFold(filepath.Ext(path), ".sup")
+}
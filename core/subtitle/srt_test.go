@@ -0,0 +1,90 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package subtitle
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadSrt(t *testing.T) {
+	data := "\ufeff1\r\n00:00:01,000 --> 00:00:02,500\r\nfirst line\r\nsecond line\r\n\r\n2\r\n00:00:03.5 --> 00:00:04.25\r\n<i>italic</i>\r\n\r\n"
+	srtPath := filepath.Join(t.TempDir(), "test.srt")
+	if err := ioutil.WriteFile(srtPath, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cueList, err := ReadSrt(srtPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []Cue{
+		{Start: 1 * time.Second, End: 2500 * time.Millisecond, Text: "first line\nsecond line"},
+		{Start: 3500 * time.Millisecond, End: 4250 * time.Millisecond, Text: "<i>italic</i>"},
+	}
+	if !reflect.DeepEqual(cueList, expect) {
+		t.Errorf("got %+v, expect %+v", cueList, expect)
+	}
+}
+
+func TestWriteSrt(t *testing.T) {
+	cueList := []Cue{
+		{Start: 1 * time.Second, End: 2 * time.Second, Text: "first"},
+		{Start: 3 * time.Second, End: 3 * time.Second, Text: "empty range"},
+		{Start: 4 * time.Second, End: 5 * time.Second, Text: ""},
+		{Start: 3661001 * time.Millisecond, End: 3662000 * time.Millisecond, Text: "second"},
+	}
+	srtPath := filepath.Join(t.TempDir(), "test.srt")
+	if err := WriteSrt(srtPath, cueList); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(srtPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "1\n00:00:01,000 --> 00:00:02,000\nfirst\n\n2\n01:01:01,001 --> 01:01:02,000\nsecond\n\n"
+	if string(data) != expect {
+		t.Errorf("got %q, expect %q", string(data), expect)
+	}
+}
+
+func TestRetimeCues(t *testing.T) {
+	retimer := NewRetimer([]Range{{Start: 10 * time.Second, End: 20 * time.Second}}, 1, 1, 0)
+	cueList := []Cue{
+		{Start: 5 * time.Second, End: 8 * time.Second, Text: "trimmed"},
+		{Start: 9 * time.Second, End: 12 * time.Second, Text: "cut at start"},
+		{Start: 15 * time.Second, End: 16 * time.Second, Text: "kept"},
+		{Start: 19 * time.Second, End: 25 * time.Second, Text: "cut at end"},
+		{Start: 21 * time.Second, End: 22 * time.Second, Text: "dropped"},
+	}
+
+	expect := []Cue{
+		{Start: 0, End: 2 * time.Second, Text: "cut at start"},
+		{Start: 5 * time.Second, End: 6 * time.Second, Text: "kept"},
+		{Start: 9 * time.Second, End: 10 * time.Second, Text: "cut at end"},
+	}
+	if got := RetimeCues(cueList, retimer); !reflect.DeepEqual(got, expect) {
+		t.Errorf("got %+v, expect %+v", got, expect)
+	}
+}
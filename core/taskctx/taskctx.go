@@ -0,0 +1,85 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package taskctx
+
+import (
+	"context"
+	"sync"
+)
+
+type taskContext struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+var (
+	contextMap  = make(map[string]*taskContext)
+	contextLock sync.Mutex
+)
+
+func Register(parent context.Context, srcFile string) context.Context {
+	contextLock.Lock()
+	defer contextLock.Unlock()
+
+	if old, exist := contextMap[srcFile]; exist {
+		old.cancel()
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	contextMap[srcFile] = &taskContext{ctx: ctx, cancel: cancel}
+
+	return ctx
+}
+
+func Get(parent context.Context, srcFile string) context.Context {
+	contextLock.Lock()
+	defer contextLock.Unlock()
+
+	if tc, exist := contextMap[srcFile]; exist {
+		return tc.ctx
+	}
+	return parent
+}
+
+func Cancel(srcFile string) {
+	contextLock.Lock()
+	defer contextLock.Unlock()
+
+	if tc, exist := contextMap[srcFile]; exist {
+		tc.cancel()
+	}
+}
+
+func IsCanceled(srcFile string) bool {
+	contextLock.Lock()
+	defer contextLock.Unlock()
+
+	tc, exist := contextMap[srcFile]
+	return exist && tc.ctx.Err() != nil
+}
+
+func Release(srcFile string) {
+	contextLock.Lock()
+	defer contextLock.Unlock()
+
+	if tc, exist := contextMap[srcFile]; exist {
+		tc.cancel()
+		delete(contextMap, srcFile)
+	}
+}
@@ -0,0 +1,178 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package branch
+
+import (
+	"MonitorEncoder/core/common"
+	"MonitorEncoder/core/report"
+	"MonitorEncoder/core/status"
+	"MonitorEncoder/core/taskctx"
+	"MonitorEncoder/core/worker"
+	"MonitorEncoder/core/worker/video"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"sync"
+)
+
+type ForkWorker struct {
+	*worker.Base
+	workDirPath string
+	miscStream  chan common.Task
+}
+
+func NewForkWorker(wg *sync.WaitGroup, param *common.Parameter, id uint) *ForkWorker {
+	w := ForkWorker{
+		Base:        worker.NewWorkerBase(wg, id),
+		workDirPath: param.WorkDirPath,
+		miscStream:  make(chan common.Task),
+	}
+
+	return &w
+}
+
+func (w *ForkWorker) Start(ctx context.Context) error {
+	if w.IsRunning == true {
+		return errors.New("fork worker already running")
+	}
+
+	if _, err := os.Stat(w.workDirPath); os.IsNotExist(err) {
+		return errors.New("work dir path not exist")
+	}
+
+	if w.InputStream == nil {
+		return errors.New("input stream not set")
+	}
+
+	w.IsRunning = true
+	w.Wg.Add(1)
+	go w.workerLoop(ctx)
+	log.Printf("[info] %s started\n", w.GetPrettyName())
+
+	return nil
+}
+
+func (w *ForkWorker) GetPrettyName() string {
+	return fmt.Sprintf("fork worker #%d", w.Id)
+}
+
+func (w *ForkWorker) GetMiscStream() chan common.Task {
+	return w.miscStream
+}
+
+func (w *ForkWorker) workerLoop(ctx context.Context) {
+	defer func() {
+		w.IsRunning = false
+		w.Wg.Done()
+		log.Printf("[info] %s exited\n", w.GetPrettyName())
+	}()
+
+	exitFlag := false
+	for {
+		if exitFlag == true {
+			log.Printf("[info] %s receive exit signal\n", w.GetPrettyName())
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			exitFlag = true
+			continue
+		case task := <-w.InputStream:
+			report.Reset(task.Src)
+			err := w.handleNewTask(ctx, &task)
+			if err != nil {
+				report.Release(task.Src)
+				log.Printf("[error] %s encounter error during handle task %s: %s\n", w.GetPrettyName(), task.Src, err.Error())
+				continue
+			}
+
+			taskctx.Register(ctx, task.Src)
+			log.Printf("[info] %s dispatch task: %s\n", w.GetPrettyName(), task.Src)
+
+			if !w.dispatch(ctx, task) {
+				exitFlag = true
+				continue
+			}
+		}
+
+		runtime.Gosched()
+	}
+}
+
+func (w *ForkWorker) handleNewTask(ctx context.Context, task *common.Task) error {
+	srcFile := task.Src
+	scriptPath, err := video.GenerateVpyFile(w.workDirPath, task)
+	if err != nil {
+		status.SetStatusCode(srcFile, status.ERROR)
+		status.SetStatusDesc(srcFile, err.Error())
+		return err
+	}
+
+	task.ScriptFile = scriptPath
+
+	if !miscNeedsIndex(task) {
+		return nil
+	}
+
+	ctx = report.WithSrc(ctx, srcFile)
+	status.SetStatusDesc(srcFile, "indexing")
+	report.StartStage(srcFile, "index")
+	err = video.IndexTask(ctx, scriptPath, task)
+	if err == nil {
+		err = video.IndexSource(ctx, w.workDirPath, task)
+	}
+	report.FinishStage(srcFile, "index")
+	if err != nil {
+		status.SetStatusCode(srcFile, status.ERROR)
+		status.SetStatusDesc(srcFile, "indexing failed: "+err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func miscNeedsIndex(task *common.Task) bool {
+	if len(task.TrimList) > 0 {
+		return true
+	}
+	if task.SourceInfo == nil || task.SourceInfo.FPSNum == 0 || task.SourceInfo.FPSDen == 0 {
+		return true
+	}
+	return task.SourceInfo.DurationMs <= 0
+}
+
+func (w *ForkWorker) dispatch(ctx context.Context, task common.Task) bool {
+	outputStream := w.OutputStream
+	miscStream := w.miscStream
+	for outputStream != nil || miscStream != nil {
+		select {
+		case <-ctx.Done():
+			return false
+		case outputStream <- task.Clone():
+			outputStream = nil
+		case miscStream <- task.Clone():
+			miscStream = nil
+		}
+	}
+	return true
+}
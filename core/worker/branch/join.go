@@ -0,0 +1,167 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package branch
+
+import (
+	"MonitorEncoder/core/common"
+	"MonitorEncoder/core/report"
+	"MonitorEncoder/core/status"
+	"MonitorEncoder/core/taskctx"
+	"MonitorEncoder/core/worker"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"sync"
+)
+
+type pendingTask struct {
+	video *common.Task
+	misc  *common.Task
+}
+
+type JoinWorker struct {
+	*worker.Base
+	miscStream <-chan common.Task
+	pendingMap map[string]*pendingTask
+}
+
+func NewJoinWorker(wg *sync.WaitGroup, id uint) *JoinWorker {
+	w := JoinWorker{
+		Base:       worker.NewWorkerBase(wg, id),
+		pendingMap: make(map[string]*pendingTask),
+	}
+
+	return &w
+}
+
+func (w *JoinWorker) Start(ctx context.Context) error {
+	if w.IsRunning == true {
+		return errors.New("join worker already running")
+	}
+
+	if w.InputStream == nil {
+		return errors.New("input stream not set")
+	}
+
+	if w.miscStream == nil {
+		return errors.New("misc stream not set")
+	}
+
+	w.IsRunning = true
+	w.Wg.Add(1)
+	go w.workerLoop(ctx)
+	log.Printf("[info] %s started\n", w.GetPrettyName())
+
+	return nil
+}
+
+func (w *JoinWorker) GetPrettyName() string {
+	return fmt.Sprintf("join worker #%d", w.Id)
+}
+
+func (w *JoinWorker) SetMiscStream(miscStream <-chan common.Task) {
+	w.miscStream = miscStream
+}
+
+func (w *JoinWorker) workerLoop(ctx context.Context) {
+	defer func() {
+		w.IsRunning = false
+		w.Wg.Done()
+		log.Printf("[info] %s exited\n", w.GetPrettyName())
+	}()
+
+	exitFlag := false
+	for {
+		if exitFlag == true {
+			log.Printf("[info] %s receive exit signal\n", w.GetPrettyName())
+			break
+		}
+
+		var joinedTask *common.Task
+		select {
+		case <-ctx.Done():
+			exitFlag = true
+			continue
+		case task := <-w.InputStream:
+			joinedTask = w.addHalf(task.Src, &task, nil)
+		case task := <-w.miscStream:
+			joinedTask = w.addHalf(task.Src, nil, &task)
+		}
+
+		if joinedTask != nil {
+			log.Printf("[info] %s joined task: %s\n", w.GetPrettyName(), joinedTask.Src)
+
+			select {
+			case <-ctx.Done():
+				exitFlag = true
+				continue
+			case w.OutputStream <- *joinedTask:
+			}
+		}
+
+		runtime.Gosched()
+	}
+}
+
+func (w *JoinWorker) addHalf(srcFile string, videoTask *common.Task, miscTask *common.Task) *common.Task {
+	pending, exist := w.pendingMap[srcFile]
+	if !exist {
+		pending = &pendingTask{}
+		w.pendingMap[srcFile] = pending
+	}
+
+	if videoTask != nil {
+		pending.video = videoTask
+	}
+	if miscTask != nil {
+		pending.misc = miscTask
+	}
+
+	isCanceled := taskctx.IsCanceled(srcFile)
+	if pending.video == nil {
+		if !isCanceled {
+			status.SetStatusDesc(srcFile, "misc task done, waiting for video")
+		}
+		return nil
+	}
+	if pending.misc == nil {
+		if !isCanceled {
+			status.SetStatusDesc(srcFile, "video task done, waiting for misc")
+		}
+		return nil
+	}
+
+	delete(w.pendingMap, srcFile)
+	taskctx.Release(srcFile)
+
+	if isCanceled {
+		log.Printf("[info] %s drop canceled task: %s\n", w.GetPrettyName(), srcFile)
+		report.Release(srcFile)
+		return nil
+	}
+
+	joinedTask := pending.video.Clone()
+	for _, result := range pending.misc.GetResultList() {
+		joinedTask.AddResult(result)
+	}
+
+	return &joinedTask
+}
@@ -0,0 +1,261 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package final
+
+import (
+	"MonitorEncoder/core/common"
+	"MonitorEncoder/core/report"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+type taskReport struct {
+	Src       string            `json:"src"`
+	Task      *common.Task      `json:"task"`
+	Tools     map[string]string `json:"tools"`
+	Commands  []string          `json:"commands"`
+	Stages    []report.Stage    `json:"stages"`
+	Frames    uint              `json:"frames"`
+	FPS       string            `json:"fps"`
+	EncodeFPS float64           `json:"encode_fps"`
+	Tracks    []trackReport     `json:"tracks"`
+	Outputs   []outputReport    `json:"outputs"`
+}
+
+type trackReport struct {
+	Category string `json:"category"`
+	Track    uint   `json:"track"`
+	Codec    string `json:"codec"`
+	Language string `json:"language"`
+	Name     string `json:"name"`
+	Default  *bool  `json:"default,omitempty"`
+	Forced   bool   `json:"forced"`
+	Delay    int    `json:"delay"`
+}
+
+type outputReport struct {
+	File   string `json:"file"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+	Crc32  string `json:"crc32"`
+
+	path string
+}
+
+const (
+	reportExt = ".report.json"
+	sha256Ext = ".sha256"
+	sfvExt    = ".sfv"
+)
+
+var (
+	toolVersionMap    = make(map[string]string)
+	toolVersionLock   sync.Mutex
+	toolVersionRegexp = regexp.MustCompile(`\d+\.\d+|\b[rR]\d+\b`)
+)
+
+func newTaskReport(ctx context.Context, task *common.Task) *taskReport {
+	record := report.Get(task.Src)
+
+	taskReport := taskReport{
+		Src:      task.Src,
+		Task:     task,
+		Tools:    make(map[string]string),
+		Commands: make([]string, 0, len(record.CommandList)),
+		Stages:   record.StageList,
+		Frames:   task.TotalFrameNum,
+		Tracks:   make([]trackReport, 0),
+		Outputs:  make([]outputReport, 0),
+	}
+
+	for _, command := range record.CommandList {
+		taskReport.Commands = append(taskReport.Commands, command.String())
+		toolName := strings.TrimSuffix(filepath.Base(command.Path), filepath.Ext(command.Path))
+		if _, exist := taskReport.Tools[toolName]; !exist {
+			taskReport.Tools[toolName] = getToolVersion(ctx, command.Path)
+		}
+	}
+
+	if task.FPSDen > 0 {
+		taskReport.FPS = fmt.Sprintf("%d/%d", task.FPSNum, task.FPSDen)
+	}
+	if seconds := record.StageSeconds("encode"); seconds > 0 {
+		taskReport.EncodeFPS = float64(task.TotalFrameNum) / seconds
+	}
+
+	for _, result := range task.GetResultList() {
+		taskReport.Tracks = append(taskReport.Tracks, trackReport{
+			Category: result.Category.Name(),
+			Track:    result.Track,
+			Codec:    strings.TrimPrefix(strings.ToLower(filepath.Ext(result.Path)), "."),
+			Language: result.Lang,
+			Name:     result.Name,
+			Default:  result.Default,
+			Forced:   result.Forced,
+			Delay:    result.Delay,
+		})
+	}
+
+	return &taskReport
+}
+
+func (r *taskReport) addOutput(outputDirPath string, path string) error {
+	output, err := checksumFile(path)
+	if err != nil {
+		return err
+	}
+
+	output.File, err = filepath.Rel(outputDirPath, path)
+	if err != nil {
+		output.File = filepath.Base(path)
+	}
+	r.Outputs = append(r.Outputs, *output)
+
+	return nil
+}
+
+func (r *taskReport) write(path string) error {
+	data, err := json.MarshalIndent(r, "", "    ")
+	if err != nil {
+		return errors.New("failed to marshal report: " + err.Error())
+	}
+
+	err = ioutil.WriteFile(path, data, 0666)
+	if err != nil {
+		return errors.New("failed to write report: " + err.Error())
+	}
+
+	return nil
+}
+
+func checksumFile(path string) (*outputReport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.New("failed to open output file: " + err.Error())
+	}
+	defer file.Close()
+
+	sha256Hash := sha256.New()
+	crc32Hash := crc32.NewIEEE()
+	size, err := io.Copy(io.MultiWriter(sha256Hash, crc32Hash), file)
+	if err != nil {
+		return nil, errors.New("failed to read output file: " + err.Error())
+	}
+
+	return &outputReport{
+		File:   filepath.Base(path),
+		path:   path,
+		Size:   size,
+		Sha256: hex.EncodeToString(sha256Hash.Sum(nil)),
+		Crc32:  fmt.Sprintf("%08X", crc32Hash.Sum32()),
+	}, nil
+}
+
+func writeSha256File(path string, outputList []outputReport) error {
+	var builder strings.Builder
+	for _, output := range outputList {
+		builder.WriteString(fmt.Sprintf("%s *%s\n", output.Sha256, checksumFileName(path, output)))
+	}
+
+	err := ioutil.WriteFile(path, []byte(builder.String()), 0666)
+	if err != nil {
+		return errors.New("failed to write sha256 file: " + err.Error())
+	}
+	return nil
+}
+
+func writeSfvFile(path string, outputList []outputReport) error {
+	var builder strings.Builder
+	builder.WriteString("; Generated by MonitorEncoder\n")
+	for _, output := range outputList {
+		builder.WriteString(fmt.Sprintf("%s %s\n", checksumFileName(path, output), output.Crc32))
+	}
+
+	err := ioutil.WriteFile(path, []byte(builder.String()), 0666)
+	if err != nil {
+		return errors.New("failed to write sfv file: " + err.Error())
+	}
+	return nil
+}
+
+func checksumFileName(checksumPath string, output outputReport) string {
+	name, err := filepath.Rel(filepath.Dir(checksumPath), output.path)
+	if err != nil {
+		return filepath.Base(output.path)
+	}
+	return name
+}
+
+func getToolVersion(ctx context.Context, path string) string {
+	toolVersionLock.Lock()
+	defer toolVersionLock.Unlock()
+
+	if version, exist := toolVersionMap[path]; exist {
+		return version
+	}
+
+	versionCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	data, _ := exec.CommandContext(versionCtx, path, toolVersionArgs(path)...).CombinedOutput()
+	version := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if version == "" {
+			version = line
+		}
+		if toolVersionRegexp.MatchString(line) {
+			version = line
+			break
+		}
+	}
+
+	toolVersionMap[path] = version
+	return version
+}
+
+func toolVersionArgs(path string) []string {
+	switch path {
+	case common.GetFFmpegPath(), common.GetMp4boxPath():
+		return []string{"-version"}
+	case common.GetQaacPath():
+		return []string{"--check"}
+	case common.GetEac3toPath(), common.GetFdkaacPath():
+		return nil
+	default:
+		return []string{"--version"}
+	}
+}
@@ -20,6 +20,7 @@ package final
 
 import (
 	"MonitorEncoder/core/common"
+	"MonitorEncoder/core/report"
 	"MonitorEncoder/core/status"
 	"MonitorEncoder/core/worker"
 	"context"
@@ -27,8 +28,11 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
+	"time"
 )
 
 type Worker struct {
@@ -98,6 +102,7 @@ func (w *Worker) workerLoop(ctx context.Context) {
 			log.Printf("[info] %s handle task: %s\n", w.GetPrettyName(), task.Src)
 			err := w.handleNewTask(ctx, &task)
 			if err != nil {
+				report.Release(task.Src)
 				log.Printf("[error] %s encounter error during handle task %s: %s\n", w.GetPrettyName(), task.Src, err.Error())
 				continue
 			}
@@ -113,51 +118,153 @@ func (w *Worker) handleNewTask(ctx context.Context, task *common.Task) error {
 	status.SetStatusCode(srcFile, status.FINAL)
 	status.SetStatusDesc(srcFile, "copying output files")
 
-	err := common.MoveFile(ctx, task.ScriptFile, w.outputDirPath)
+	fileList, err := w.newOutputFileList(task)
 	if err != nil {
 		status.SetStatusCode(srcFile, status.ERROR)
-		status.SetStatusDesc(srcFile, "failed to move "+task.ScriptFile)
+		status.SetStatusDesc(srcFile, err.Error())
 		return err
 	}
 
-	err = common.MoveFile(ctx, task.TaskFile, w.outputDirPath)
+	dstPathList, err := common.ResolveOutputFiles(w.outputDirPath, fileList, task.OutputCollision)
 	if err != nil {
 		status.SetStatusCode(srcFile, status.ERROR)
-		status.SetStatusDesc(srcFile, "failed to move "+task.TaskFile)
+		status.SetStatusDesc(srcFile, err.Error())
 		return err
 	}
 
-	resultList := task.GetResultList()
+	deliveredList := make([]string, 0, len(fileList))
+	sidecarPathMap := make(map[string]string)
+	for i, file := range fileList {
+		dstPath := dstPathList[i]
+		if file.SrcPath == "" {
+			sidecarPathMap[file.Rest] = dstPath
+			continue
+		}
 
-	if task.MuxedFile != "" {
-		for _, result := range resultList {
-			err = common.DeleteFile(ctx, result.Path)
-			if err != nil {
-				status.SetStatusCode(srcFile, status.ERROR)
-				status.SetStatusDesc(srcFile, "failed to delete "+result.Path)
-				return err
+		err = os.MkdirAll(filepath.Dir(dstPath), 0777)
+		if err == nil {
+			if task.OutputCollision == common.OutputCollisionOverwrite {
+				err = common.ReplaceFile(ctx, file.SrcPath, dstPath)
+			} else {
+				err = common.MoveFile(ctx, file.SrcPath, dstPath)
 			}
 		}
-
-		err = common.MoveFile(ctx, task.MuxedFile, w.outputDirPath)
 		if err != nil {
 			status.SetStatusCode(srcFile, status.ERROR)
-			status.SetStatusDesc(srcFile, "failed to move "+task.MuxedFile)
+			status.SetStatusDesc(srcFile, "failed to move "+file.SrcPath)
 			return err
 		}
-	} else {
-		for _, result := range resultList {
-			err = common.MoveFile(ctx, result.Path, w.outputDirPath)
+		deliveredList = append(deliveredList, dstPath)
+	}
+
+	if len(task.MuxedFileList) > 0 {
+		for _, result := range task.GetResultList() {
+			err = common.DeleteFile(ctx, result.Path)
 			if err != nil {
 				status.SetStatusCode(srcFile, status.ERROR)
-				status.SetStatusDesc(srcFile, "failed to move "+result.Path)
+				status.SetStatusDesc(srcFile, "failed to delete "+result.Path)
 				return err
 			}
 		}
 	}
 
+	status.SetStatusDesc(srcFile, "writing report")
+	err = w.writeReport(ctx, task, deliveredList, sidecarPathMap)
+	if err != nil {
+		status.SetStatusCode(srcFile, status.ERROR)
+		status.SetStatusDesc(srcFile, err.Error())
+		return err
+	}
+
 	status.SetStatusCode(srcFile, status.DONE)
 	status.SetStatusDesc(srcFile, "everything is finished")
 
 	return nil
 }
+
+func (w *Worker) newOutputFileList(task *common.Task) ([]common.OutputFile, error) {
+	prefix := common.GenerateFileNamePrefix(task.Src)
+	name := prefix
+	if task.OutputName != "" {
+		var err error
+		name, err = task.RenderOutputName(time.Now())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	newOutputFile := func(path string) common.OutputFile {
+		baseName := filepath.Base(path)
+		if strings.HasPrefix(baseName, prefix) {
+			return common.OutputFile{SrcPath: path, Name: name, Rest: strings.TrimPrefix(baseName, prefix)}
+		}
+		return common.OutputFile{SrcPath: path, Name: name, Rest: "." + baseName}
+	}
+
+	fileList := []common.OutputFile{newOutputFile(task.ScriptFile)}
+
+	if _, err := os.Stat(task.TaskFile); err == nil {
+		ext := filepath.Ext(task.TaskFile)
+		if task.OutputName != "" {
+			fileList = append(fileList, common.OutputFile{SrcPath: task.TaskFile, Name: name, Rest: ".task" + ext})
+		} else {
+			fileList = append(fileList, common.OutputFile{SrcPath: task.TaskFile, Name: strings.TrimSuffix(filepath.Base(task.TaskFile), ext), Rest: ext})
+		}
+	}
+
+	fileList = append(fileList, newOutputFile(task.EffectiveFile))
+
+	if len(task.MuxedFileList) > 0 {
+		for _, muxedFile := range task.MuxedFileList {
+			fileList = append(fileList, newOutputFile(muxedFile))
+		}
+	} else {
+		for _, result := range task.GetResultList() {
+			fileList = append(fileList, newOutputFile(result.Path))
+		}
+	}
+
+	sidecarList := []string{reportExt, sha256Ext}
+	if task.Sfv {
+		sidecarList = append(sidecarList, sfvExt)
+	}
+	for _, sidecarExt := range sidecarList {
+		fileList = append(fileList, common.OutputFile{Name: name, Rest: sidecarExt})
+	}
+
+	return fileList, nil
+}
+
+func (w *Worker) writeReport(ctx context.Context, task *common.Task, deliveredList []string, sidecarPathMap map[string]string) error {
+	defer report.Release(task.Src)
+
+	taskReport := newTaskReport(ctx, task)
+	for _, path := range deliveredList {
+		err := taskReport.addOutput(w.outputDirPath, path)
+		if err != nil {
+			return err
+		}
+	}
+
+	reportPath := sidecarPathMap[reportExt]
+	err := taskReport.write(reportPath)
+	if err != nil {
+		return err
+	}
+
+	err = taskReport.addOutput(w.outputDirPath, reportPath)
+	if err != nil {
+		return err
+	}
+
+	err = writeSha256File(sidecarPathMap[sha256Ext], taskReport.Outputs)
+	if err != nil {
+		return err
+	}
+
+	if sfvPath, exist := sidecarPathMap[sfvExt]; exist {
+		return writeSfvFile(sfvPath, taskReport.Outputs)
+	}
+
+	return nil
+}
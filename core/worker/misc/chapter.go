@@ -0,0 +1,150 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package misc
+
+import (
+	"MonitorEncoder/core/bdmv"
+	"MonitorEncoder/core/chapter"
+	"MonitorEncoder/core/common"
+	"MonitorEncoder/core/subtitle"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const defaultChapterInterval = 5
+
+func ValidateChapters(task *common.Task) error {
+	switch task.ChapterFormat {
+	case "", common.ChapterFormatOgm, common.ChapterFormatXml:
+	default:
+		return fmt.Errorf("unknown chapter format: %s", task.ChapterFormat)
+	}
+
+	switch task.Chapters {
+	case "", common.ChaptersNone, common.ChaptersAuto:
+		return nil
+	}
+
+	if _, err := os.Stat(task.Chapters); err != nil {
+		return fmt.Errorf("chapter file not exist: %s", task.Chapters)
+	}
+
+	return nil
+}
+
+func HasChapters(task *common.Task) bool {
+	switch task.Chapters {
+	case common.ChaptersNone:
+		return false
+	case "":
+		return bdmv.IsPlaylist(task.Src)
+	}
+	return true
+}
+
+func GenerateChapters(task *common.Task, workDirPath string) (string, error) {
+	retimer, err := newRetimer(task, 0)
+	if err != nil {
+		return "", err
+	}
+
+	duration := chapterDuration(task, retimer)
+
+	var chapterList []chapter.Chapter
+	switch task.Chapters {
+	case common.ChaptersAuto:
+		if duration <= 0 {
+			return "", errors.New("unknown source duration for auto chapters")
+		}
+
+		interval := task.ChapterInterval
+		if interval == 0 {
+			interval = defaultChapterInterval
+		}
+		chapterList = chapter.Auto(duration, time.Duration(interval)*time.Minute)
+	case "":
+		playlist, err := bdmv.ParsePlaylist(task.Src)
+		if err != nil {
+			return "", err
+		}
+		chapterList = retimeChapters(playlist.Chapters(), retimer, duration)
+	default:
+		sourceChapterList, err := chapter.Read(task.Chapters)
+		if err != nil {
+			return "", err
+		}
+		chapterList = retimeChapters(sourceChapterList, retimer, duration)
+	}
+
+	if len(chapterList) <= 0 {
+		return "", nil
+	}
+
+	outputPath := common.GenerateNewFilePath(task.Src, workDirPath, "chapters.txt", "", 0)
+	writeChapters := chapter.WriteOgm
+	if task.ChapterFormat == common.ChapterFormatXml {
+		outputPath = common.GenerateNewFilePath(task.Src, workDirPath, "chapters.xml", "", 0)
+		writeChapters = chapter.WriteXml
+	}
+
+	err = writeChapters(outputPath, chapterList)
+	if err != nil {
+		return "", err
+	}
+
+	return outputPath, nil
+}
+
+func chapterDuration(task *common.Task, retimer *subtitle.Retimer) time.Duration {
+	if task.TotalFrameNum > 0 && task.FPSNum > 0 && task.FPSDen > 0 {
+		return time.Duration(int64(task.TotalFrameNum) * int64(time.Second) * int64(task.FPSDen) / int64(task.FPSNum))
+	}
+	if task.SourceInfo != nil && task.SourceInfo.DurationMs > 0 {
+		return retimer.Map(time.Duration(task.SourceInfo.DurationMs) * time.Millisecond)
+	}
+	return 0
+}
+
+func retimeChapterFile(task *common.Task, rawPath string, outputPath string, retimer *subtitle.Retimer) error {
+	chapterList, err := chapter.Read(rawPath)
+	if err != nil {
+		return err
+	}
+	return chapter.WriteOgm(outputPath, retimeChapters(chapterList, retimer, chapterDuration(task, retimer)))
+}
+
+func retimeChapters(chapterList []chapter.Chapter, retimer *subtitle.Retimer, duration time.Duration) []chapter.Chapter {
+	retimedList := make([]chapter.Chapter, 0, len(chapterList))
+	for _, c := range chapterList {
+		c.Start = retimer.Map(c.Start)
+		if duration > 0 && c.Start >= duration {
+			continue
+		}
+
+		last := len(retimedList) - 1
+		if last >= 0 && c.Start <= retimedList[last].Start {
+			retimedList[last] = c
+			continue
+		}
+		retimedList = append(retimedList, c)
+	}
+	return retimedList
+}
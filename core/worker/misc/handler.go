@@ -20,31 +20,41 @@ package misc
 
 import (
 	"MonitorEncoder/core/common"
+	"MonitorEncoder/core/report"
 	"context"
 	"errors"
 	"fmt"
 	"os/exec"
 )
 
-type AudioCodecHandler func(context.Context, string, string, *common.AudioTask) (string, error)
+type AudioCodecHandler func(context.Context, *common.Task, string, *common.AudioTask) (string, error)
 
 var AudioCodecHandlerMap = map[string]AudioCodecHandler{
-	"flac": handlerFLAC,
-	"opus": handlerOpus,
-	"aac":  handlerAAC,
-	"ac3":  generateAudioCopyHandler("ac3"),
-	"dts":  generateAudioCopyHandler("dts"),
-	"thd":  generateAudioCopyHandler("thd"),
+	"flac":      handlerFLAC,
+	"opus":      handlerOpus,
+	"aac":       handlerAAC,
+	"fdkaac":    generateFdkaacHandler("2"),
+	"fdkaac-he": generateFdkaacHandler("5"),
+	"ffaac":     generateFFmpegHandler("aac", "aac"),
+	"ac3":       handlerAC3,
+	"ac3-copy":  generateAudioCopyHandler("ac3"),
+	"eac3":      generateFFmpegHandler("eac3", "eac3"),
+	"wav":       handlerWav,
+	"pcm":       handlerWav,
+	"dts":       generateAudioCopyHandler("dts"),
+	"thd":       generateAudioCopyHandler("thd"),
 }
 
-func handlerFLAC(ctx context.Context, srcPath string, workDirPath string, audioTask *common.AudioTask) (string, error) {
+func handlerFLAC(ctx context.Context, task *common.Task, workDirPath string, audioTask *common.AudioTask) (string, error) {
+	srcPath := task.Src
 	outputPath := common.GenerateNewFilePath(srcPath, workDirPath, "flac", audioTask.Language, audioTask.Track)
 
-	track := fmt.Sprintf("%d:", audioTask.Track)
-	eac3toParam := []string{srcPath, track, outputPath, "-log=NUL"}
+	eac3toParam := eac3toInputParam(task, audioTask)
+	eac3toParam = append(eac3toParam, outputPath, "-log=NUL")
+	eac3toParam = append(eac3toParam, eac3toAudioParam(task, audioTask)...)
 
 	eac3toPath := common.GetEac3toPath()
-	eac3toProcess := exec.CommandContext(ctx, eac3toPath, eac3toParam...)
+	eac3toProcess := report.NewCommand(ctx, eac3toPath, eac3toParam...)
 	err := eac3toProcess.Run()
 	if err != nil {
 		return "", err
@@ -53,11 +63,8 @@ func handlerFLAC(ctx context.Context, srcPath string, workDirPath string, audioT
 	return outputPath, nil
 }
 
-func handlerOpus(ctx context.Context, srcPath string, workDirPath string, audioTask *common.AudioTask) (string, error) {
-	outputPath := common.GenerateNewFilePath(srcPath, workDirPath, "opus", audioTask.Language, audioTask.Track)
-
-	track := fmt.Sprintf("%d:", audioTask.Track)
-	eac3toParam := []string{srcPath, track, "stdout.wav", "-log=NUL"}
+func handlerOpus(ctx context.Context, task *common.Task, workDirPath string, audioTask *common.AudioTask) (string, error) {
+	outputPath := common.GenerateNewFilePath(task.Src, workDirPath, "opus", audioTask.Language, audioTask.Track)
 
 	if audioTask.Bitrate <= 0 {
 		return "", errors.New("invalid bitrate setting for opus codec")
@@ -65,29 +72,24 @@ func handlerOpus(ctx context.Context, srcPath string, workDirPath string, audioT
 	bitrate := fmt.Sprintf("%d", audioTask.Bitrate)
 	opusencParam := []string{"--ignorelength", "--vbr", "--bitrate", bitrate, "-", outputPath}
 
-	eac3toPath := common.GetEac3toPath()
-	eac3toProcess := exec.CommandContext(ctx, eac3toPath, eac3toParam...)
-
-	opusencPath := common.GetOpusencPath()
-	opusencProcess := exec.CommandContext(ctx, opusencPath, opusencParam...)
-	opusencProcess.Stdin, _ = eac3toProcess.StdoutPipe()
-
-	err := eac3toProcess.Start()
+	err := pipeEac3to(ctx, task, audioTask, common.GetOpusencPath(), opusencParam)
 	if err != nil {
 		return "", err
 	}
 
-	err = opusencProcess.Start()
-	if err != nil {
-		return "", err
-	}
+	return outputPath, nil
+}
 
-	err = opusencProcess.Wait()
-	if err != nil {
-		return "", err
+func handlerAAC(ctx context.Context, task *common.Task, workDirPath string, audioTask *common.AudioTask) (string, error) {
+	outputPath := common.GenerateNewFilePath(task.Src, workDirPath, "aac", audioTask.Language, audioTask.Track)
+
+	if audioTask.Bitrate <= 0 {
+		return "", errors.New("invalid bitrate setting for aac codec")
 	}
+	bitrate := fmt.Sprintf("%d", audioTask.Bitrate)
+	qaacParam := []string{"--adts", "-v", bitrate, "-o", outputPath, "-"}
 
-	err = eac3toProcess.Wait()
+	err := pipeEac3to(ctx, task, audioTask, common.GetQaacPath(), qaacParam)
 	if err != nil {
 		return "", err
 	}
@@ -95,57 +97,111 @@ func handlerOpus(ctx context.Context, srcPath string, workDirPath string, audioT
 	return outputPath, nil
 }
 
-func handlerAAC(ctx context.Context, srcPath string, workDirPath string, audioTask *common.AudioTask) (string, error) {
-	outputPath := common.GenerateNewFilePath(srcPath, workDirPath, "aac", audioTask.Language, audioTask.Track)
+func generateFdkaacHandler(profile string) AudioCodecHandler {
+	return func(ctx context.Context, task *common.Task, workDirPath string, audioTask *common.AudioTask) (string, error) {
+		outputPath := common.GenerateNewFilePath(task.Src, workDirPath, "aac", audioTask.Language, audioTask.Track)
 
-	track := fmt.Sprintf("%d:", audioTask.Track)
-	eac3toParam := []string{srcPath, track, "stdout.wav", "-log=NUL"}
+		if audioTask.Bitrate <= 0 {
+			return "", errors.New("invalid bitrate setting for fdkaac codec")
+		}
+		bitrate := fmt.Sprintf("%d", audioTask.Bitrate)
+		fdkaacParam := []string{"--ignorelength", "-p", profile, "-b", bitrate, "-f", "2", "-o", outputPath, "-"}
 
-	if audioTask.Bitrate <= 0 {
-		return "", errors.New("invalid bitrate setting for aac codec")
+		err := pipeEac3to(ctx, task, audioTask, common.GetFdkaacPath(), fdkaacParam)
+		if err != nil {
+			return "", err
+		}
+
+		return outputPath, nil
 	}
-	bitrate := fmt.Sprintf("%d", audioTask.Bitrate)
-	qaacParam := []string{"--adts", "-v", bitrate, "-o", outputPath, "-"}
+}
 
-	eac3toPath := common.GetEac3toPath()
-	eac3toProcess := exec.CommandContext(ctx, eac3toPath, eac3toParam...)
+func generateFFmpegHandler(codec string, ext string) AudioCodecHandler {
+	return func(ctx context.Context, task *common.Task, workDirPath string, audioTask *common.AudioTask) (string, error) {
+		outputPath := common.GenerateNewFilePath(task.Src, workDirPath, ext, audioTask.Language, audioTask.Track)
 
-	qaacPath := common.GetQaacPath()
-	qaacProcess := exec.CommandContext(ctx, qaacPath, qaacParam...)
-	qaacProcess.Stdin, _ = eac3toProcess.StdoutPipe()
+		if audioTask.Bitrate <= 0 {
+			return "", fmt.Errorf("invalid bitrate setting for %s codec", codec)
+		}
+		bitrate := fmt.Sprintf("%dk", audioTask.Bitrate)
+		ffmpegParam := []string{"-hide_banner", "-nostdin", "-y", "-i", "-", "-c:a", codec, "-b:a", bitrate, outputPath}
 
-	err := eac3toProcess.Start()
+		err := pipeEac3to(ctx, task, audioTask, common.GetFFmpegPath(), ffmpegParam)
+		if err != nil {
+			return "", err
+		}
+
+		return outputPath, nil
+	}
+}
+
+func handlerWav(ctx context.Context, task *common.Task, workDirPath string, audioTask *common.AudioTask) (string, error) {
+	outputPath := common.GenerateNewFilePath(task.Src, workDirPath, "wav", audioTask.Language, audioTask.Track)
+
+	eac3toParam := eac3toInputParam(task, audioTask)
+	eac3toParam = append(eac3toParam, outputPath, "-log=NUL")
+	eac3toParam = append(eac3toParam, eac3toAudioParam(task, audioTask)...)
+
+	eac3toPath := common.GetEac3toPath()
+	eac3toProcess := report.NewCommand(ctx, eac3toPath, eac3toParam...)
+	err := eac3toProcess.Run()
 	if err != nil {
-		return "", nil
+		return "", err
+	}
+
+	return outputPath, nil
+}
+
+func handlerAC3(ctx context.Context, task *common.Task, workDirPath string, audioTask *common.AudioTask) (string, error) {
+	if audioTask.Bitrate <= 0 {
+		return generateAudioCopyHandler("ac3")(ctx, task, workDirPath, audioTask)
 	}
+	return generateFFmpegHandler("ac3", "ac3")(ctx, task, workDirPath, audioTask)
+}
+
+func pipeEac3to(ctx context.Context, task *common.Task, audioTask *common.AudioTask, encoderPath string, encoderParam []string) error {
+	eac3toParam := eac3toInputParam(task, audioTask)
+	eac3toParam = append(eac3toParam, "stdout.wav", "-log=NUL")
+	eac3toParam = append(eac3toParam, eac3toAudioParam(task, audioTask)...)
+
+	encoderProcess := report.NewCommand(ctx, encoderPath, encoderParam...)
+	return runEac3toPipe(ctx, eac3toParam, encoderProcess)
+}
+
+func runEac3toPipe(ctx context.Context, eac3toParam []string, encoderProcess *exec.Cmd) error {
+	eac3toPath := common.GetEac3toPath()
+	eac3toProcess := report.NewCommand(ctx, eac3toPath, eac3toParam...)
+	encoderProcess.Stdin, _ = eac3toProcess.StdoutPipe()
 
-	err = qaacProcess.Start()
+	err := eac3toProcess.Start()
 	if err != nil {
-		return "", nil
+		return err
 	}
 
-	err = qaacProcess.Wait()
+	err = encoderProcess.Start()
 	if err != nil {
-		return "", nil
+		return err
 	}
 
-	err = eac3toProcess.Wait()
+	err = encoderProcess.Wait()
 	if err != nil {
-		return "", nil
+		return err
 	}
 
-	return outputPath, nil
+	return eac3toProcess.Wait()
 }
 
 func generateAudioCopyHandler(ext string) AudioCodecHandler {
-	return func(ctx context.Context, srcPath string, workDirPath string, audioTask *common.AudioTask) (string, error) {
+	return func(ctx context.Context, task *common.Task, workDirPath string, audioTask *common.AudioTask) (string, error) {
+		srcPath := task.Src
 		outputPath := common.GenerateNewFilePath(srcPath, workDirPath, ext, audioTask.Language, audioTask.Track)
 
-		track := fmt.Sprintf("%d:", audioTask.Track)
-		eac3toParam := []string{srcPath, track, outputPath, "-log=NUL"}
+		eac3toParam := eac3toInputParam(task, audioTask)
+		eac3toParam = append(eac3toParam, outputPath, "-log=NUL")
+		eac3toParam = append(eac3toParam, eac3toAudioParam(task, audioTask)...)
 
 		eac3toPath := common.GetEac3toPath()
-		eac3toProcess := exec.CommandContext(ctx, eac3toPath, eac3toParam...)
+		eac3toProcess := report.NewCommand(ctx, eac3toPath, eac3toParam...)
 		err := eac3toProcess.Run()
 		if err != nil {
 			return "", err
@@ -155,23 +211,227 @@ func generateAudioCopyHandler(ext string) AudioCodecHandler {
 	}
 }
 
-func Demux(ctx context.Context, srcFile string, workDirPath string, demuxTask *common.DemuxTask) (string, error) {
-	outputPath := common.GenerateNewFilePath(srcFile, workDirPath, demuxTask.Format, demuxTask.Language, demuxTask.Track)
+func IsCopyCodec(audioTask *common.AudioTask) bool {
+	switch audioTask.Codec {
+	case "ac3":
+		return audioTask.Bitrate <= 0
+	case "ac3-copy", "dts", "thd":
+		return true
+	}
+	return false
+}
 
-	track := fmt.Sprintf("%d:", demuxTask.Track)
-	eac3toParam := []string{srcFile, track, outputPath, "-log=NUL"}
+func TrimAudio(ctx context.Context, task *common.Task, workDirPath string, audioTask *common.AudioTask) (string, error) {
+	fpsNum, fpsDen := GetSourceFPS(task)
+
+	extractPath := common.GenerateNewFilePath(task.Src, workDirPath, "wav", audioTask.Language, audioTask.Track)
+	trimmedPath := common.GenerateNewFilePath(task.Src, workDirPath, "trim.wav", audioTask.Language, audioTask.Track)
+
+	eac3toParam := eac3toInputParam(task, audioTask)
+	eac3toParam = append(eac3toParam, extractPath, "-log=NUL")
+	eac3toParam = append(eac3toParam, eac3toPrepareParam(task, audioTask)...)
 
 	eac3toPath := common.GetEac3toPath()
-	eac3toProcess := exec.CommandContext(ctx, eac3toPath, eac3toParam...)
-	err := eac3toProcess.Start()
+	eac3toProcess := report.NewCommand(ctx, eac3toPath, eac3toParam...)
+	err := eac3toProcess.Run()
 	if err != nil {
 		return "", err
 	}
 
-	err = eac3toProcess.Wait()
+	err = TrimWav(extractPath, trimmedPath, task.TrimList, task.SourceFrameNum, fpsNum, fpsDen)
+	deleteErr := common.DeleteFile(ctx, extractPath)
 	if err != nil {
 		return "", err
 	}
+	if deleteErr != nil {
+		return "", deleteErr
+	}
 
-	return outputPath, nil
+	return trimmedPath, nil
+}
+
+func eac3toInputParam(task *common.Task, audioTask *common.AudioTask) []string {
+	if audioTask.InputFile != "" {
+		return []string{audioTask.InputFile}
+	}
+	return []string{task.Src, fmt.Sprintf("%d:", audioTask.Track)}
+}
+
+func GetAudioDelay(task *common.Task, audioTask *common.AudioTask) int {
+	if task.SourceInfo == nil {
+		return 0
+	}
+
+	trackInfo, found := task.SourceInfo.GetTrack(audioTask.Track)
+	if !found {
+		return 0
+	}
+
+	return trackInfo.Delay
+}
+
+func GetSourceFPS(task *common.Task) (uint, uint) {
+	if task.SourceInfo != nil && task.SourceInfo.FPSNum > 0 && task.SourceInfo.FPSDen > 0 {
+		return task.SourceInfo.FPSNum, task.SourceInfo.FPSDen
+	}
+	if task.TargetFPSNum == 0 {
+		return task.FPSNum, task.FPSDen
+	}
+	return 0, 0
+}
+
+func IsFPSChanged(task *common.Task) bool {
+	if task.TargetFPSNum == 0 || task.TargetFPSDen == 0 {
+		return false
+	}
+
+	fpsNum, fpsDen := GetSourceFPS(task)
+	return uint64(fpsNum)*uint64(task.TargetFPSDen) != uint64(fpsDen)*uint64(task.TargetFPSNum)
+}
+
+func ValidateAudioFPS(task *common.Task, audioTask *common.AudioTask) error {
+	if !IsFPSChanged(task) {
+		return nil
+	}
+
+	if IsCopyCodec(audioTask) {
+		return fmt.Errorf("can not change the frame rate of audio track %d with codec %s", audioTask.Track, audioTask.Codec)
+	}
+
+	_, err := eac3toFPSParam(task)
+	if err != nil {
+		return fmt.Errorf("can not change the frame rate of audio track %d: %s", audioTask.Track, err.Error())
+	}
+
+	return nil
+}
+
+func ValidateAudioTask(audioTask *common.AudioTask) error {
+	switch audioTask.DelayMode {
+	case "", common.DelayModeMux, common.DelayModeEac3to, common.DelayModeIgnore:
+	default:
+		return fmt.Errorf("unknown delay mode for track %d: %s", audioTask.Track, audioTask.DelayMode)
+	}
+
+	if _, exist := downmixParamMap[audioTask.Downmix]; !exist {
+		return fmt.Errorf("unknown downmix for track %d: %s", audioTask.Track, audioTask.Downmix)
+	}
+
+	if _, exist := channelsParamMap[audioTask.Channels]; !exist {
+		return fmt.Errorf("unsupported channels for track %d: %d", audioTask.Track, audioTask.Channels)
+	}
+
+	if audioTask.Downmix != "" && audioTask.Channels != 0 && audioTask.Channels != 2 {
+		return fmt.Errorf("downmix %s of track %d produces 2 channels, not %d", audioTask.Downmix, audioTask.Track, audioTask.Channels)
+	}
+
+	switch audioTask.SampleRate {
+	case 0, 44100, 48000, 96000:
+	default:
+		return fmt.Errorf("unsupported samplerate for track %d: %d", audioTask.Track, audioTask.SampleRate)
+	}
+
+	if audioTask.Codec == "ac3-copy" && audioTask.Bitrate > 0 {
+		return fmt.Errorf("codec ac3-copy of track %d can not take a bitrate, use codec ac3 to encode", audioTask.Track)
+	}
+
+	if IsCopyCodec(audioTask) && (audioTask.Downmix != "" || audioTask.Channels != 0 || audioTask.SampleRate != 0) {
+		return fmt.Errorf("can not remix or resample track %d with codec %s", audioTask.Track, audioTask.Codec)
+	}
+
+	if audioTask.Loudness != nil {
+		if IsCopyCodec(audioTask) {
+			return fmt.Errorf("can not normalize loudness of track %d with codec %s", audioTask.Track, audioTask.Codec)
+		}
+		if err := ValidateLoudnessTarget(audioTask.Loudness); err != nil {
+			return fmt.Errorf("invalid loudness setting for track %d: %s", audioTask.Track, err.Error())
+		}
+	}
+
+	return nil
+}
+
+var downmixParamMap = map[string]string{
+	"":       "",
+	"stereo": "-down2",
+	"dpl2":   "-downDpl",
+}
+
+var channelsParamMap = map[uint]string{
+	0: "",
+	2: "-down2",
+	6: "-down6",
+}
+
+var eac3toFPSMap = map[[2]uint]string{
+	{24000, 1001}: "23.976",
+	{24, 1}:       "24.000",
+	{25, 1}:       "25.000",
+}
+
+func eac3toAudioParam(task *common.Task, audioTask *common.AudioTask) []string {
+	eac3toParam := make([]string, 0)
+	if audioTask.InputFile == "" {
+		eac3toParam = append(eac3toParam, eac3toDelayParam(task, audioTask)...)
+		eac3toParam = append(eac3toParam, eac3toMixParam(audioTask)...)
+	}
+
+	fpsParam, _ := eac3toFPSParam(task)
+	return append(eac3toParam, fpsParam...)
+}
+
+func eac3toFPSParam(task *common.Task) ([]string, error) {
+	if !IsFPSChanged(task) {
+		return []string{}, nil
+	}
+
+	fpsNum, fpsDen := GetSourceFPS(task)
+	srcFPS, srcExist := eac3toFPSMap[[2]uint{fpsNum, fpsDen}]
+	dstFPS, dstExist := eac3toFPSMap[[2]uint{task.TargetFPSNum, task.TargetFPSDen}]
+	if !srcExist || !dstExist {
+		return nil, fmt.Errorf("eac3to can not change %d/%d fps to %d/%d fps", fpsNum, fpsDen, task.TargetFPSNum, task.TargetFPSDen)
+	}
+
+	return []string{"-" + srcFPS, "-changeTo" + dstFPS}, nil
+}
+
+func eac3toPrepareParam(task *common.Task, audioTask *common.AudioTask) []string {
+	if audioTask.InputFile != "" {
+		return []string{}
+	}
+
+	eac3toParam := make([]string, 0)
+	if delay := GetAudioDelay(task, audioTask); delay != 0 && audioTask.DelayMode != common.DelayModeIgnore {
+		eac3toParam = append(eac3toParam, fmt.Sprintf("%+dms", delay))
+	}
+	return append(eac3toParam, eac3toMixParam(audioTask)...)
+}
+
+func eac3toMixParam(audioTask *common.AudioTask) []string {
+	eac3toParam := make([]string, 0)
+
+	if audioTask.Downmix != "" {
+		eac3toParam = append(eac3toParam, downmixParamMap[audioTask.Downmix])
+	} else if audioTask.Channels != 0 {
+		eac3toParam = append(eac3toParam, channelsParamMap[audioTask.Channels])
+	}
+
+	if audioTask.SampleRate != 0 {
+		eac3toParam = append(eac3toParam, fmt.Sprintf("-resampleTo%d", audioTask.SampleRate))
+	}
+
+	return eac3toParam
+}
+
+func eac3toDelayParam(task *common.Task, audioTask *common.AudioTask) []string {
+	if audioTask.DelayMode != common.DelayModeEac3to {
+		return []string{}
+	}
+
+	delay := GetAudioDelay(task, audioTask)
+	if delay == 0 {
+		return []string{}
+	}
+
+	return []string{fmt.Sprintf("%+dms", delay)}
 }
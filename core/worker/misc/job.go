@@ -0,0 +1,58 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package misc
+
+import (
+	"MonitorEncoder/core/common"
+	"context"
+	"sync"
+)
+
+type miscJob struct {
+	name string
+	run  func(context.Context) ([]common.Result, error)
+}
+
+func runMiscJobs(ctx context.Context, jobList []miscJob, jobNum int) ([][]common.Result, []error) {
+	resultListList := make([][]common.Result, len(jobList))
+	errList := make([]error, len(jobList))
+
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, jobNum)
+	for i, job := range jobList {
+		select {
+		case <-ctx.Done():
+			errList[i] = ctx.Err()
+			continue
+		case semaphore <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(i int, job miscJob) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			resultListList[i], errList[i] = job.run(ctx)
+		}(i, job)
+	}
+	wg.Wait()
+
+	return resultListList, errList
+}
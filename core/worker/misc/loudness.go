@@ -0,0 +1,146 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package misc
+
+import (
+	"MonitorEncoder/core/common"
+	"MonitorEncoder/core/report"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const defaultTruePeak = -1.0
+
+type loudnormStats struct {
+	InputI       string `json:"input_i"`
+	InputTp      string `json:"input_tp"`
+	InputLra     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	TargetOffset string `json:"target_offset"`
+}
+
+func ValidateLoudnessTarget(loudness *common.Loudness) error {
+	if loudness.TargetLufs < -70 || loudness.TargetLufs > -5 {
+		return fmt.Errorf("target_lufs %.1f out of range [-70, -5]", loudness.TargetLufs)
+	}
+	if loudness.TruePeak < -9 || loudness.TruePeak > 0 {
+		return fmt.Errorf("true_peak %.1f out of range [-9, 0]", loudness.TruePeak)
+	}
+	if loudness.Lra != 0 && (loudness.Lra < 1 || loudness.Lra > 50) {
+		return fmt.Errorf("lra %.1f out of range [1, 50]", loudness.Lra)
+	}
+	return nil
+}
+
+func loudnormTargetParam(loudness *common.Loudness) string {
+	truePeak := loudness.TruePeak
+	if truePeak == 0 {
+		truePeak = defaultTruePeak
+	}
+
+	param := fmt.Sprintf("loudnorm=I=%.1f:TP=%.1f", loudness.TargetLufs, truePeak)
+	if loudness.Lra != 0 {
+		param += fmt.Sprintf(":LRA=%.1f", loudness.Lra)
+	}
+	return param
+}
+
+func NormalizeLoudness(ctx context.Context, task *common.Task, workDirPath string, audioTask *common.AudioTask) (string, *common.LoudnessInfo, error) {
+	eac3toParam := eac3toInputParam(task, audioTask)
+	eac3toParam = append(eac3toParam, "stdout.wav", "-log=NUL")
+	eac3toParam = append(eac3toParam, eac3toPrepareParam(task, audioTask)...)
+
+	targetParam := loudnormTargetParam(audioTask.Loudness)
+	ffmpegPath := common.GetFFmpegPath()
+
+	measureParam := []string{"-hide_banner", "-nostdin", "-i", "-", "-af", targetParam + ":print_format=json", "-f", "null", "-"}
+	var measureOutput bytes.Buffer
+	measureProcess := report.NewCommand(ctx, ffmpegPath, measureParam...)
+	measureProcess.Stderr = &measureOutput
+	err := runEac3toPipe(ctx, eac3toParam, measureProcess)
+	if err != nil {
+		return "", nil, errors.New("failed to measure loudness: " + err.Error())
+	}
+
+	stats, err := parseLoudnormOutput(measureOutput.String())
+	if err != nil {
+		return "", nil, err
+	}
+
+	loudnessInfo := common.LoudnessInfo{}
+	for _, field := range []struct {
+		value string
+		dst   *float64
+	}{
+		{stats.InputI, &loudnessInfo.Integrated},
+		{stats.InputTp, &loudnessInfo.TruePeak},
+		{stats.InputLra, &loudnessInfo.Lra},
+	} {
+		*field.dst, err = strconv.ParseFloat(field.value, 64)
+		if err != nil {
+			return "", nil, errors.New("invalid loudnorm measurement: " + field.value)
+		}
+	}
+
+	outputPath := common.GenerateNewFilePath(task.Src, workDirPath, "loudnorm.wav", audioTask.Language, audioTask.Track)
+	normalizeFilter := fmt.Sprintf("%s:measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true",
+		targetParam, stats.InputI, stats.InputTp, stats.InputLra, stats.InputThresh, stats.TargetOffset)
+	normalizeParam := []string{"-hide_banner", "-nostdin", "-y", "-i", "-", "-af", normalizeFilter,
+		"-ar", fmt.Sprintf("%d", getOutputSampleRate(task, audioTask)), "-c:a", "pcm_s24le", "-rf64", "auto", outputPath}
+	normalizeProcess := report.NewCommand(ctx, ffmpegPath, normalizeParam...)
+	err = runEac3toPipe(ctx, eac3toParam, normalizeProcess)
+	if err != nil {
+		return "", nil, errors.New("failed to normalize loudness: " + err.Error())
+	}
+
+	return outputPath, &loudnessInfo, nil
+}
+
+func parseLoudnormOutput(output string) (*loudnormStats, error) {
+	start := strings.LastIndex(output, "{")
+	end := strings.LastIndex(output, "}")
+	if start < 0 || end < start {
+		return nil, errors.New("no loudnorm measurement found in ffmpeg output")
+	}
+
+	var stats loudnormStats
+	err := json.Unmarshal([]byte(output[start:end+1]), &stats)
+	if err != nil {
+		return nil, errors.New("failed to parse loudnorm measurement: " + err.Error())
+	}
+
+	return &stats, nil
+}
+
+func getOutputSampleRate(task *common.Task, audioTask *common.AudioTask) uint {
+	if audioTask.SampleRate > 0 {
+		return audioTask.SampleRate
+	}
+	if task.SourceInfo != nil {
+		if trackInfo, found := task.SourceInfo.GetTrack(audioTask.Track); found && trackInfo.SampleRate > 0 {
+			return trackInfo.SampleRate
+		}
+	}
+	return 48000
+}
@@ -0,0 +1,195 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package misc
+
+import (
+	"MonitorEncoder/core/common"
+	"MonitorEncoder/core/report"
+	"MonitorEncoder/core/subtitle"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func ValidateDemuxTask(demuxTask *common.DemuxTask) error {
+	if demuxTask.Convert == "" {
+		return nil
+	}
+
+	switch demuxTask.Convert {
+	case "srt", "ass":
+	default:
+		return fmt.Errorf("unsupported subtitle conversion for track %d: %s", demuxTask.Track, demuxTask.Convert)
+	}
+
+	switch strings.ToLower(demuxTask.Format) {
+	case "srt", "ass", "ssa":
+	default:
+		return fmt.Errorf("can not convert track %d from %s to %s", demuxTask.Track, demuxTask.Format, demuxTask.Convert)
+	}
+
+	return nil
+}
+
+func demuxCategory(demuxTask *common.DemuxTask) common.ResultCategory {
+	switch strings.ToLower(demuxTask.Format) {
+	case "txt", "xml":
+		return common.ResultChapters
+	}
+
+	if subtitle.IsSubtitle("." + demuxTask.Format) {
+		return common.ResultSubtitle
+	}
+
+	return common.ResultAudio
+}
+
+func Demux(ctx context.Context, task *common.Task, workDirPath string, demuxTask *common.DemuxTask) (string, error) {
+	srcFile := task.Src
+	outputFormat := demuxTask.Format
+	if demuxTask.Convert != "" {
+		outputFormat = demuxTask.Convert
+	}
+	isSubtitle := subtitle.IsSubtitle("." + demuxTask.Format)
+	isChapters := demuxCategory(demuxTask) == common.ResultChapters
+	var retimer *subtitle.Retimer
+	if isSubtitle || isChapters {
+		var err error
+		retimer, err = newRetimer(task, demuxTask.Shift)
+		if err != nil {
+			return "", err
+		}
+	}
+	if isChapters && !retimer.IsIdentity() {
+		outputFormat = "txt"
+	}
+	outputPath := common.GenerateNewFilePath(srcFile, workDirPath, outputFormat, demuxTask.Language, demuxTask.Track)
+
+	if !(isSubtitle || isChapters) || (outputFormat == demuxTask.Format && retimer.IsIdentity()) {
+		err := extractTrack(ctx, srcFile, demuxTask.Track, outputPath)
+		if err != nil {
+			return "", err
+		}
+		return outputPath, nil
+	}
+
+	rawPath := common.GenerateNewFilePath(srcFile, workDirPath, "raw."+demuxTask.Format, demuxTask.Language, demuxTask.Track)
+	err := extractTrack(ctx, srcFile, demuxTask.Track, rawPath)
+	if err != nil {
+		return "", err
+	}
+
+	if isChapters {
+		err = retimeChapterFile(task, rawPath, outputPath, retimer)
+	} else {
+		err = convertSubtitle(rawPath, outputPath, demuxTask, retimer)
+	}
+	deleteErr := common.DeleteFile(ctx, rawPath)
+	if err != nil {
+		return "", err
+	}
+	if deleteErr != nil {
+		return "", deleteErr
+	}
+
+	return outputPath, nil
+}
+
+func extractTrack(ctx context.Context, srcFile string, track uint, outputPath string) error {
+	eac3toParam := []string{srcFile, fmt.Sprintf("%d:", track), outputPath, "-log=NUL"}
+
+	eac3toPath := common.GetEac3toPath()
+	eac3toProcess := report.NewCommand(ctx, eac3toPath, eac3toParam...)
+	err := eac3toProcess.Start()
+	if err != nil {
+		return err
+	}
+
+	return eac3toProcess.Wait()
+}
+
+func convertSubtitle(rawPath string, outputPath string, demuxTask *common.DemuxTask, retimer *subtitle.Retimer) error {
+	switch strings.ToLower(demuxTask.Format) {
+	case "sup":
+		return subtitle.RetimeSup(rawPath, outputPath, retimer)
+	case "srt":
+		cueList, err := subtitle.ReadSrt(rawPath)
+		if err != nil {
+			return err
+		}
+		cueList = subtitle.RetimeCues(cueList, retimer)
+		if demuxTask.Convert != "ass" {
+			return subtitle.WriteSrt(outputPath, cueList)
+		}
+		ass, err := subtitle.NewAssFromCues(cueList, demuxTask.Style)
+		if err != nil {
+			return err
+		}
+		return ass.Write(outputPath)
+	default:
+		ass, err := subtitle.ReadAss(rawPath)
+		if err != nil {
+			return err
+		}
+		ass.Retime(retimer)
+		if demuxTask.Convert == "srt" {
+			return subtitle.WriteSrt(outputPath, ass.ToCues())
+		}
+		return ass.Write(outputPath)
+	}
+}
+
+func newRetimer(task *common.Task, shiftMs int) (*subtitle.Retimer, error) {
+	fpsNum, fpsDen := GetSourceFPS(task)
+
+	needFPS := len(task.TrimList) > 0 || task.TargetFPSNum > 0
+	if needFPS && (fpsNum == 0 || fpsDen == 0) {
+		return nil, errors.New("unknown source frame rate for re-timing")
+	}
+
+	rangeList := make([]subtitle.Range, 0, len(task.TrimList))
+	if len(task.TrimList) > 0 {
+		if task.SourceFrameNum == 0 {
+			return nil, errors.New("unknown source frame count for re-timing")
+		}
+
+		frameToTime := func(frame int) time.Duration {
+			return time.Duration(int64(frame) * int64(time.Second) * int64(fpsDen) / int64(fpsNum))
+		}
+		for _, trim := range task.TrimList {
+			start, end, err := trim.Resolve(int(task.SourceFrameNum))
+			if err != nil {
+				return nil, err
+			}
+			rangeList = append(rangeList, subtitle.Range{Start: frameToTime(start), End: frameToTime(end)})
+		}
+	}
+
+	scaleNum, scaleDen := int64(1), int64(1)
+	if task.TargetFPSNum > 0 && task.TargetFPSDen > 0 {
+		scaleNum = int64(fpsNum) * int64(task.TargetFPSDen)
+		scaleDen = int64(fpsDen) * int64(task.TargetFPSNum)
+	}
+
+	shift := time.Duration(shiftMs) * time.Millisecond
+
+	return subtitle.NewRetimer(rangeList, scaleNum, scaleDen, shift), nil
+}
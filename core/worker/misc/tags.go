@@ -0,0 +1,73 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package misc
+
+import (
+	"MonitorEncoder/core/common"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"sort"
+)
+
+type matroskaTags struct {
+	XMLName xml.Name      `xml:"Tags"`
+	TagList []matroskaTag `xml:"Tag"`
+}
+
+type matroskaTag struct {
+	TargetTypeValue int              `xml:"Targets>TargetTypeValue"`
+	SimpleList      []matroskaSimple `xml:"Simple"`
+}
+
+type matroskaSimple struct {
+	Name   string `xml:"Name"`
+	String string `xml:"String"`
+}
+
+func WriteTags(task *common.Task, workDirPath string) (string, error) {
+	tagMap := task.GetMetadata()
+	if len(tagMap) <= 0 {
+		return "", nil
+	}
+
+	nameList := make([]string, 0, len(tagMap))
+	for name := range tagMap {
+		nameList = append(nameList, name)
+	}
+	sort.Strings(nameList)
+
+	tag := matroskaTag{TargetTypeValue: 50}
+	for _, name := range nameList {
+		tag.SimpleList = append(tag.SimpleList, matroskaSimple{Name: name, String: tagMap[name]})
+	}
+
+	data, err := xml.MarshalIndent(matroskaTags{TagList: []matroskaTag{tag}}, "", "  ")
+	if err != nil {
+		return "", errors.New("failed to marshal tags: " + err.Error())
+	}
+
+	outputPath := common.GenerateNewFilePath(task.Src, workDirPath, "tags.xml", "", 0)
+	err = ioutil.WriteFile(outputPath, append([]byte(xml.Header), data...), 0644)
+	if err != nil {
+		return "", errors.New("failed to write tags: " + err.Error())
+	}
+
+	return outputPath, nil
+}
@@ -0,0 +1,240 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package misc
+
+import (
+	"MonitorEncoder/core/common"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+type wavInfo struct {
+	fmtChunk   []byte
+	sampleRate int64
+	blockAlign int64
+	dataOffset int64
+	dataSize   int64
+}
+
+func readWavInfo(wavFile *os.File) (*wavInfo, error) {
+	fileInfo, err := wavFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	fileSize := fileInfo.Size()
+
+	header := make([]byte, 12)
+	if _, err = io.ReadFull(wavFile, header); err != nil {
+		return nil, errors.New("failed to read wav header: " + err.Error())
+	}
+	riffId := string(header[0:4])
+	if (riffId != "RIFF" && riffId != "RF64") || string(header[8:12]) != "WAVE" {
+		return nil, errors.New("not a wav file: " + wavFile.Name())
+	}
+
+	info := wavInfo{}
+	var ds64DataSize int64 = -1
+	pos := int64(12)
+	for pos+8 <= fileSize {
+		chunkHeader := make([]byte, 8)
+		if _, err = wavFile.ReadAt(chunkHeader, pos); err != nil {
+			return nil, errors.New("failed to read wav chunk: " + err.Error())
+		}
+		chunkId := string(chunkHeader[0:4])
+		chunkSize := int64(binary.LittleEndian.Uint32(chunkHeader[4:8]))
+		pos += 8
+
+		switch chunkId {
+		case "ds64":
+			ds64 := make([]byte, 16)
+			if _, err = wavFile.ReadAt(ds64, pos); err != nil {
+				return nil, errors.New("failed to read ds64 chunk: " + err.Error())
+			}
+			ds64DataSize = int64(binary.LittleEndian.Uint64(ds64[8:16]))
+		case "fmt ":
+			if chunkSize < 16 {
+				return nil, errors.New("invalid wav fmt chunk")
+			}
+			info.fmtChunk = make([]byte, chunkSize)
+			if _, err = wavFile.ReadAt(info.fmtChunk, pos); err != nil {
+				return nil, errors.New("failed to read wav fmt chunk: " + err.Error())
+			}
+			info.sampleRate = int64(binary.LittleEndian.Uint32(info.fmtChunk[4:8]))
+			info.blockAlign = int64(binary.LittleEndian.Uint16(info.fmtChunk[12:14]))
+		case "data":
+			info.dataOffset = pos
+			info.dataSize = chunkSize
+			if chunkSize == math.MaxUint32 && ds64DataSize >= 0 {
+				info.dataSize = ds64DataSize
+			}
+			if info.dataSize == 0 || info.dataSize == math.MaxUint32 || pos+info.dataSize > fileSize {
+				info.dataSize = fileSize - pos
+			}
+		}
+
+		if chunkId == "data" {
+			break
+		}
+		pos += chunkSize + chunkSize%2
+	}
+
+	if info.fmtChunk == nil || info.dataOffset == 0 {
+		return nil, errors.New("incomplete wav file: " + wavFile.Name())
+	}
+	if info.sampleRate <= 0 || info.blockAlign <= 0 {
+		return nil, errors.New("invalid wav format: " + wavFile.Name())
+	}
+
+	return &info, nil
+}
+
+func TrimWav(srcPath string, dstPath string, trimList []common.Trim, totalFrameNum uint, fpsNum uint, fpsDen uint) error {
+	if fpsNum == 0 || fpsDen == 0 {
+		return errors.New("unknown frame rate for audio trimming")
+	}
+	if totalFrameNum == 0 {
+		return errors.New("unknown video frame count for audio trimming")
+	}
+
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		return errors.New("failed to open wav file: " + err.Error())
+	}
+	defer srcFile.Close()
+
+	info, err := readWavInfo(srcFile)
+	if err != nil {
+		return err
+	}
+
+	frameToSample := func(frame int) int64 {
+		return (int64(frame)*int64(fpsDen)*info.sampleRate + int64(fpsNum)/2) / int64(fpsNum)
+	}
+
+	sampleNum := info.dataSize / info.blockAlign
+
+	sectionList := make([]*io.SectionReader, 0, len(trimList))
+	var dataSize int64
+	for _, trim := range trimList {
+		start, end, err := trim.Resolve(int(totalFrameNum))
+		if err != nil {
+			return err
+		}
+
+		startSample := frameToSample(start)
+		endSample := frameToSample(end)
+		if endSample > sampleNum {
+			endSample = sampleNum
+		}
+		if startSample > endSample {
+			startSample = endSample
+		}
+
+		size := (endSample - startSample) * info.blockAlign
+		sectionList = append(sectionList, io.NewSectionReader(srcFile, info.dataOffset+startSample*info.blockAlign, size))
+		dataSize += size
+	}
+
+	dstFile, err := os.Create(dstPath)
+	if err != nil {
+		return errors.New("failed to create wav file: " + err.Error())
+	}
+
+	err = writeWav(dstFile, info, sectionList, dataSize)
+	closeErr := dstFile.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write wav file %s: %s", dstPath, err.Error())
+	}
+
+	return closeErr
+}
+
+func writeWav(w io.Writer, info *wavInfo, sectionList []*io.SectionReader, dataSize int64) error {
+	writer := bufio.NewWriterSize(w, 1<<20)
+	err := writeWavHeader(writer, info, dataSize)
+	if err != nil {
+		return err
+	}
+
+	for _, section := range sectionList {
+		if _, err = io.Copy(writer, section); err != nil {
+			return err
+		}
+	}
+
+	return writer.Flush()
+}
+
+func writeWavHeader(w io.Writer, info *wavInfo, dataSize int64) error {
+	fmtSize := int64(len(info.fmtChunk))
+	fmtPadding := fmtSize % 2
+	riffSize := 4 + 8 + fmtSize + fmtPadding + 8 + dataSize
+	isRf64 := riffSize > math.MaxUint32
+
+	header := make([]byte, 0, 80+fmtSize)
+	u32 := func(v uint32) {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, v)
+		header = append(header, b...)
+	}
+	u64 := func(v uint64) {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, v)
+		header = append(header, b...)
+	}
+
+	if isRf64 {
+		riffSize += 8 + 28
+		header = append(header, "RF64"...)
+		u32(math.MaxUint32)
+		header = append(header, "WAVE"...)
+		header = append(header, "ds64"...)
+		u32(28)
+		u64(uint64(riffSize))
+		u64(uint64(dataSize))
+		u64(uint64(dataSize / info.blockAlign))
+		u32(0)
+	} else {
+		header = append(header, "RIFF"...)
+		u32(uint32(riffSize))
+		header = append(header, "WAVE"...)
+	}
+
+	header = append(header, "fmt "...)
+	u32(uint32(fmtSize))
+	header = append(header, info.fmtChunk...)
+	if fmtPadding != 0 {
+		header = append(header, 0)
+	}
+
+	header = append(header, "data"...)
+	if isRf64 {
+		u32(math.MaxUint32)
+	} else {
+		u32(uint32(dataSize))
+	}
+
+	_, err := w.Write(header)
+	return err
+}
@@ -0,0 +1,206 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package misc
+
+import (
+	"MonitorEncoder/core/common"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func buildTestWav(sampleRate uint32, sampleNum int, withListChunk bool) []byte {
+	fmtChunk := make([]byte, 16)
+	binary.LittleEndian.PutUint16(fmtChunk[0:2], 1)
+	binary.LittleEndian.PutUint16(fmtChunk[2:4], 1)
+	binary.LittleEndian.PutUint32(fmtChunk[4:8], sampleRate)
+	binary.LittleEndian.PutUint32(fmtChunk[8:12], sampleRate*2)
+	binary.LittleEndian.PutUint16(fmtChunk[12:14], 2)
+	binary.LittleEndian.PutUint16(fmtChunk[14:16], 16)
+
+	data := make([]byte, sampleNum*2)
+	for i := 0; i < sampleNum; i++ {
+		binary.LittleEndian.PutUint16(data[i*2:], uint16(i))
+	}
+
+	chunk := func(id string, body []byte) []byte {
+		header := make([]byte, 8)
+		copy(header, id)
+		binary.LittleEndian.PutUint32(header[4:8], uint32(len(body)))
+		if len(body)%2 != 0 {
+			body = append(body, 0)
+		}
+		return append(header, body...)
+	}
+
+	body := []byte("WAVE")
+	body = append(body, chunk("fmt ", fmtChunk)...)
+	if withListChunk {
+		body = append(body, chunk("LIST", []byte("INFOodd"))...)
+	}
+	body = append(body, chunk("data", data)...)
+	return append(chunk("RIFF", body)[:8], body...)
+}
+
+func readTestWav(t *testing.T, path string) (int64, []uint16) {
+	wavFile, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wavFile.Close()
+
+	info, err := readWavInfo(wavFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, info.dataSize)
+	if _, err = wavFile.ReadAt(data, info.dataOffset); err != nil {
+		t.Fatal(err)
+	}
+	sampleList := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		sampleList = append(sampleList, binary.LittleEndian.Uint16(data[i:]))
+	}
+	return info.sampleRate, sampleList
+}
+
+func sampleRange(start int, end int) []uint16 {
+	sampleList := make([]uint16, 0, end-start)
+	for i := start; i < end; i++ {
+		sampleList = append(sampleList, uint16(i))
+	}
+	return sampleList
+}
+
+func TestTrimWav(t *testing.T) {
+	testList := []struct {
+		name          string
+		sampleRate    uint32
+		sampleNum     int
+		withList      bool
+		trimList      []common.Trim
+		totalFrameNum uint
+		fpsNum        uint
+		fpsDen        uint
+		expect        []uint16
+	}{
+		{
+			name:          "single range",
+			sampleRate:    1000,
+			sampleNum:     4000,
+			trimList:      []common.Trim{{Start: 10, End: 20}},
+			totalFrameNum: 100,
+			fpsNum:        25,
+			fpsDen:        1,
+			expect:        sampleRange(400, 800),
+		},
+		{
+			name:          "ranges from the end are joined",
+			sampleRate:    1000,
+			sampleNum:     4000,
+			withList:      true,
+			trimList:      []common.Trim{{Start: 0, End: 5}, {Start: -10, End: 0}},
+			totalFrameNum: 100,
+			fpsNum:        25,
+			fpsDen:        1,
+			expect:        append(sampleRange(0, 200), sampleRange(3600, 4000)...),
+		},
+		{
+			name:          "ntsc frame rate rounds to the nearest sample",
+			sampleRate:    48000,
+			sampleNum:     48000,
+			trimList:      []common.Trim{{Start: 1, End: 3}},
+			totalFrameNum: 20,
+			fpsNum:        24000,
+			fpsDen:        1001,
+			expect:        sampleRange(2002, 6006),
+		},
+		{
+			name:          "audio shorter than the video",
+			sampleRate:    1000,
+			sampleNum:     3000,
+			trimList:      []common.Trim{{Start: 70, End: 0}, {Start: 90, End: 0}},
+			totalFrameNum: 100,
+			fpsNum:        25,
+			fpsDen:        1,
+			expect:        sampleRange(2800, 3000),
+		},
+	}
+
+	for _, test := range testList {
+		dirPath := t.TempDir()
+		srcPath := filepath.Join(dirPath, "src.wav")
+		dstPath := filepath.Join(dirPath, "dst.wav")
+		err := ioutil.WriteFile(srcPath, buildTestWav(test.sampleRate, test.sampleNum, test.withList), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = TrimWav(srcPath, dstPath, test.trimList, test.totalFrameNum, test.fpsNum, test.fpsDen)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+			continue
+		}
+
+		sampleRate, sampleList := readTestWav(t, dstPath)
+		if sampleRate != int64(test.sampleRate) {
+			t.Errorf("%s: got sample rate %d, expect %d", test.name, sampleRate, test.sampleRate)
+		}
+		if !reflect.DeepEqual(sampleList, test.expect) {
+			t.Errorf("%s: got %d samples, expect %d", test.name, len(sampleList), len(test.expect))
+		}
+	}
+}
+
+func TestTrimWavError(t *testing.T) {
+	dirPath := t.TempDir()
+	srcPath := filepath.Join(dirPath, "src.wav")
+	notWavPath := filepath.Join(dirPath, "src.txt")
+	dstPath := filepath.Join(dirPath, "dst.wav")
+	if err := ioutil.WriteFile(srcPath, buildTestWav(1000, 4000, false), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(notWavPath, []byte("not a wav file at all"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testList := []struct {
+		name          string
+		srcPath       string
+		trimList      []common.Trim
+		totalFrameNum uint
+		fpsNum        uint
+	}{
+		{name: "unknown frame rate", srcPath: srcPath, trimList: []common.Trim{{Start: 0, End: 10}}, totalFrameNum: 100},
+		{name: "unknown frame count", srcPath: srcPath, trimList: []common.Trim{{Start: 0, End: 10}}, fpsNum: 25},
+		{name: "range out of the video", srcPath: srcPath, trimList: []common.Trim{{Start: 0, End: 200}}, totalFrameNum: 100, fpsNum: 25},
+		{name: "not a wav file", srcPath: notWavPath, trimList: []common.Trim{{Start: 0, End: 10}}, totalFrameNum: 100, fpsNum: 25},
+	}
+
+	for _, test := range testList {
+		err := TrimWav(test.srcPath, dstPath, test.trimList, test.totalFrameNum, test.fpsNum, 1)
+		if err == nil {
+			t.Errorf("%s: expect an error", test.name)
+		}
+	}
+}
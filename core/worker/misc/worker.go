@@ -20,7 +20,9 @@ package misc
 
 import (
 	"MonitorEncoder/core/common"
+	"MonitorEncoder/core/report"
 	"MonitorEncoder/core/status"
+	"MonitorEncoder/core/taskctx"
 	"MonitorEncoder/core/worker"
 	"context"
 	"errors"
@@ -28,6 +30,7 @@ import (
 	"log"
 	"os"
 	"runtime"
+	"strings"
 	"sync"
 )
 
@@ -35,6 +38,7 @@ type Worker struct {
 	*worker.Base
 	workDirPath   string
 	outputDirPath string
+	jobNum        int
 }
 
 func NewMiscWorker(wg *sync.WaitGroup, param *common.Parameter, id uint) *Worker {
@@ -42,6 +46,11 @@ func NewMiscWorker(wg *sync.WaitGroup, param *common.Parameter, id uint) *Worker
 		Base:          worker.NewWorkerBase(wg, id),
 		workDirPath:   param.WorkDirPath,
 		outputDirPath: param.OutputDirPath,
+		jobNum:        param.MiscJobNum,
+	}
+
+	if w.jobNum < 1 {
+		w.jobNum = 1
 	}
 
 	return &w
@@ -95,13 +104,19 @@ func (w *Worker) workerLoop(ctx context.Context) {
 			exitFlag = true
 			continue
 		case task := <-w.InputStream:
-			log.Printf("[info] %s handle task: %s\n", w.GetPrettyName(), task.Src)
-			err := w.handleNewTask(ctx, &task)
-			if err != nil {
-				log.Printf("[error] %s encounter error during handle task %s: %s\n", w.GetPrettyName(), task.Src, err.Error())
-				continue
+			taskCtx := taskctx.Get(ctx, task.Src)
+			if taskCtx.Err() != nil {
+				log.Printf("[info] %s skip canceled task: %s\n", w.GetPrettyName(), task.Src)
+			} else {
+				log.Printf("[info] %s handle task: %s\n", w.GetPrettyName(), task.Src)
+				err := w.handleNewTask(taskCtx, &task)
+				if err != nil {
+					taskctx.Cancel(task.Src)
+					log.Printf("[error] %s encounter error during handle task %s: %s\n", w.GetPrettyName(), task.Src, err.Error())
+				} else {
+					log.Printf("[info] %s finish task: %s\n", w.GetPrettyName(), task.Src)
+				}
 			}
-			log.Printf("[info] %s finish task: %s\n", w.GetPrettyName(), task.Src)
 
 			select {
 			case <-ctx.Done():
@@ -117,6 +132,10 @@ func (w *Worker) workerLoop(ctx context.Context) {
 
 func (w *Worker) handleNewTask(ctx context.Context, task *common.Task) error {
 	srcFile := task.Src
+	ctx = report.WithSrc(ctx, srcFile)
+	report.StartStage(srcFile, "misc")
+	defer report.FinishStage(srcFile, "misc")
+
 	if _, err := os.Stat(srcFile); os.IsNotExist(err) {
 		errDesc := fmt.Sprintf("src file not exist: %s", srcFile)
 		status.SetStatusCode(srcFile, status.ERROR)
@@ -125,9 +144,18 @@ func (w *Worker) handleNewTask(ctx context.Context, task *common.Task) error {
 	}
 
 	status.SetStatusCode(srcFile, status.MISC)
-	status.SetStatusDesc(srcFile, "handling audio task")
+	status.SetStatusDesc(srcFile, "handling misc task")
+
+	jobList := make([]miscJob, 0, len(task.Audio)+len(task.Demux)+2)
 
 	for _, audioTask := range task.Audio {
+		err := ValidateAudioTask(&audioTask)
+		if err != nil {
+			status.SetStatusCode(srcFile, status.ERROR)
+			status.SetStatusDesc(srcFile, err.Error())
+			return err
+		}
+
 		codecHandler, exist := AudioCodecHandlerMap[audioTask.Codec]
 		if !exist {
 			errDesc := fmt.Sprintf("unknown audio codec for track %d: %s", audioTask.Track, audioTask.Codec)
@@ -136,36 +164,178 @@ func (w *Worker) handleNewTask(ctx context.Context, task *common.Task) error {
 			return errors.New(errDesc)
 		}
 
-		status.SetStatusDesc(srcFile, fmt.Sprintf("encoding audio track #%d to %s", audioTask.Track, audioTask.Codec))
-
-		audioPath, err := codecHandler(ctx, srcFile, w.workDirPath, &audioTask)
+		err = ValidateAudioFPS(task, &audioTask)
 		if err != nil {
 			status.SetStatusCode(srcFile, status.ERROR)
 			status.SetStatusDesc(srcFile, err.Error())
 			return err
 		}
 
-		task.AddResult(common.NewResult(audioPath, common.ResultNonVideo, audioTask.Language, audioTask.Track))
+		if len(task.TrimList) > 0 && IsCopyCodec(&audioTask) {
+			errDesc := fmt.Sprintf("can not trim audio track %d with codec %s", audioTask.Track, audioTask.Codec)
+			status.SetStatusCode(srcFile, status.ERROR)
+			status.SetStatusDesc(srcFile, errDesc)
+			return errors.New(errDesc)
+		}
+
+		audioTask := audioTask
+		jobList = append(jobList, miscJob{
+			name: fmt.Sprintf("audio track #%d", audioTask.Track),
+			run: func(ctx context.Context) ([]common.Result, error) {
+				result, err := w.handleAudioTask(ctx, task, audioTask, codecHandler)
+				return []common.Result{result}, err
+			},
+		})
+	}
 
-		runtime.Gosched()
+	err := ValidateChapters(task)
+	if err != nil {
+		status.SetStatusCode(srcFile, status.ERROR)
+		status.SetStatusDesc(srcFile, err.Error())
+		return err
 	}
 
-	status.SetStatusDesc(srcFile, "handling demux task")
+	if HasChapters(task) {
+		jobList = append(jobList, miscJob{
+			name: "chapters",
+			run: func(ctx context.Context) ([]common.Result, error) {
+				chapterPath, err := GenerateChapters(task, w.workDirPath)
+				if err != nil || chapterPath == "" {
+					return nil, err
+				}
+				return []common.Result{common.NewResult(chapterPath, common.ResultChapters, "", 0)}, nil
+			},
+		})
+	}
 
-	for _, demuxTask := range task.Demux {
-		status.SetStatusDesc(srcFile, fmt.Sprintf("demuxing track #%d, format %s", demuxTask.Track, demuxTask.Format))
+	if task.HasMetadata() {
+		jobList = append(jobList, miscJob{
+			name: "tags",
+			run: func(ctx context.Context) ([]common.Result, error) {
+				tagsPath, err := WriteTags(task, w.workDirPath)
+				if err != nil || tagsPath == "" {
+					return nil, err
+				}
+				return []common.Result{common.NewResult(tagsPath, common.ResultTags, "", 0)}, nil
+			},
+		})
+	}
 
-		outputPath, err := Demux(ctx, srcFile, w.workDirPath, &demuxTask)
+	for _, demuxTask := range task.Demux {
+		err = ValidateDemuxTask(&demuxTask)
+		if err == nil && demuxCategory(&demuxTask) == common.ResultAudio && IsFPSChanged(task) {
+			err = fmt.Errorf("can not change the frame rate of demuxed audio track %d, use an audio task instead", demuxTask.Track)
+		} else if err == nil && demuxCategory(&demuxTask) == common.ResultAudio && len(task.TrimList) > 0 {
+			err = fmt.Errorf("can not trim demuxed audio track %d, use an audio task instead", demuxTask.Track)
+		}
 		if err != nil {
 			status.SetStatusCode(srcFile, status.ERROR)
 			status.SetStatusDesc(srcFile, err.Error())
 			return err
 		}
 
-		task.AddResult(common.NewResult(outputPath, common.ResultNonVideo, demuxTask.Language, demuxTask.Track))
+		demuxTask := demuxTask
+		jobList = append(jobList, miscJob{
+			name: fmt.Sprintf("demux track #%d", demuxTask.Track),
+			run: func(ctx context.Context) ([]common.Result, error) {
+				status.SetStatusDesc(srcFile, fmt.Sprintf("demuxing track #%d, format %s", demuxTask.Track, demuxTask.Format))
 
-		runtime.Gosched()
+				outputPath, err := Demux(ctx, task, w.workDirPath, &demuxTask)
+				if err != nil {
+					return nil, err
+				}
+				result := common.NewResult(outputPath, demuxCategory(&demuxTask), demuxTask.Language, demuxTask.Track)
+				result.Name = demuxTask.Name
+				result.Default = demuxTask.Default
+				result.Forced = demuxTask.Forced
+				return []common.Result{result}, nil
+			},
+		})
+	}
+
+	resultListList, errList := runMiscJobs(ctx, jobList, w.jobNum)
+
+	errDescList := make([]string, 0)
+	for i, err := range errList {
+		if err != nil {
+			errDescList = append(errDescList, fmt.Sprintf("%s: %s", jobList[i].name, err.Error()))
+		}
+	}
+	if len(errDescList) > 0 {
+		errDesc := strings.Join(errDescList, "; ")
+		status.SetStatusCode(srcFile, status.ERROR)
+		status.SetStatusDesc(srcFile, errDesc)
+		return errors.New(errDesc)
+	}
+
+	for _, resultList := range resultListList {
+		for _, result := range resultList {
+			task.AddResult(result)
+		}
 	}
 
 	return nil
 }
+
+func (w *Worker) handleAudioTask(ctx context.Context, task *common.Task, audioTask common.AudioTask, codecHandler AudioCodecHandler) (common.Result, error) {
+	srcFile := task.Src
+
+	if len(task.TrimList) > 0 {
+		status.SetStatusDesc(srcFile, fmt.Sprintf("trimming audio track #%d", audioTask.Track))
+
+		trimmedPath, err := TrimAudio(ctx, task, w.workDirPath, &audioTask)
+		if err != nil {
+			return common.Result{}, err
+		}
+		audioTask.InputFile = trimmedPath
+	}
+
+	var loudnessInfo *common.LoudnessInfo
+	if audioTask.Loudness != nil {
+		status.SetStatusDesc(srcFile, fmt.Sprintf("normalizing loudness of audio track #%d", audioTask.Track))
+
+		normalizedPath, measured, err := NormalizeLoudness(ctx, task, w.workDirPath, &audioTask)
+		if audioTask.InputFile != "" {
+			w.deleteIntermediate(ctx, audioTask.InputFile)
+		}
+		if err != nil {
+			return common.Result{}, err
+		}
+		audioTask.InputFile = normalizedPath
+		loudnessInfo = measured
+
+		status.AddStatusReport(srcFile, fmt.Sprintf("audio track #%d: %.1f LUFS, LRA %.1f LU, true peak %.1f dBTP", audioTask.Track, measured.Integrated, measured.Lra, measured.TruePeak))
+	}
+
+	status.SetStatusDesc(srcFile, fmt.Sprintf("encoding audio track #%d to %s", audioTask.Track, audioTask.Codec))
+
+	audioPath, err := codecHandler(ctx, task, w.workDirPath, &audioTask)
+	if audioTask.InputFile != "" {
+		w.deleteIntermediate(ctx, audioTask.InputFile)
+	}
+	if err != nil {
+		return common.Result{}, err
+	}
+
+	result := common.NewResult(audioPath, common.ResultAudio, audioTask.Language, audioTask.Track)
+	result.Name = audioTask.Name
+	result.Default = audioTask.Default
+	result.Forced = audioTask.Forced
+	result.SampleRate = audioTask.SampleRate
+	result.Loudness = loudnessInfo
+	if audioTask.InputFile == "" && (audioTask.DelayMode == "" || audioTask.DelayMode == common.DelayModeMux) {
+		result.Delay = GetAudioDelay(task, &audioTask)
+	}
+	if result.Delay != 0 {
+		log.Printf("[info] %s audio track #%d has delay %dms\n", w.GetPrettyName(), audioTask.Track, result.Delay)
+	}
+
+	return result, nil
+}
+
+func (w *Worker) deleteIntermediate(ctx context.Context, path string) {
+	err := common.DeleteFile(ctx, path)
+	if err != nil {
+		log.Printf("[warning] %s failed to delete %s: %s\n", w.GetPrettyName(), path, err.Error())
+	}
+}
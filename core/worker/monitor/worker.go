@@ -20,9 +20,13 @@ package monitor
 
 import (
 	"MonitorEncoder/core/activetime"
+	"MonitorEncoder/core/bdmv"
 	"MonitorEncoder/core/common"
+	"MonitorEncoder/core/probe"
 	"MonitorEncoder/core/status"
 	"MonitorEncoder/core/worker"
+	"MonitorEncoder/core/worker/mux"
+	"MonitorEncoder/core/worker/video"
 	"context"
 	"errors"
 	"fmt"
@@ -30,23 +34,42 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
 
 type Worker struct {
 	*worker.Base
-	monitorPath string
-	recyclePath string
-	workDirPath string
+	monitorPath    string
+	recyclePath    string
+	workDirPath    string
+	presetDirPath  string
+	bdmvPreset     string
+	bdmvAudioLangs string
+	bdmvSubLangs   string
+	settleMap      map[string]*bdmvSnapshot
 }
 
+type bdmvSnapshot struct {
+	fileNum   int
+	totalSize int64
+	time      time.Time
+}
+
+const bdmvSettleTime = 1 * time.Minute
+
 func NewMonitor(wg *sync.WaitGroup, param *common.Parameter, id uint) *Worker {
 	m := Worker{
-		Base:        worker.NewWorkerBase(wg, id),
-		monitorPath: param.MonitorDirPath,
-		recyclePath: filepath.Join(param.MonitorDirPath, "recycle"),
-		workDirPath: param.WorkDirPath,
+		Base:           worker.NewWorkerBase(wg, id),
+		monitorPath:    param.MonitorDirPath,
+		recyclePath:    filepath.Join(param.MonitorDirPath, "recycle"),
+		workDirPath:    param.WorkDirPath,
+		presetDirPath:  param.PresetDirPath,
+		bdmvPreset:     param.BdmvPreset,
+		bdmvAudioLangs: param.BdmvAudioLangs,
+		bdmvSubLangs:   param.BdmvSubLangs,
+		settleMap:      make(map[string]*bdmvSnapshot),
 	}
 
 	return &m
@@ -107,10 +130,12 @@ func (w *Worker) workerLoop(ctx context.Context) {
 
 		newTaskPath := w.checkNewTask(ctx)
 		if newTaskPath != "" {
-			newTask, err := common.NewTaskFromJson(newTaskPath)
-
+			newTaskList, err := w.loadTasks(ctx, newTaskPath)
 			if err != nil {
 				log.Printf("[error] %s failed to load task: %s: %s\n", w.GetPrettyName(), newTaskPath, err.Error())
+				if fileInfo, statErr := os.Stat(newTaskPath); statErr == nil && fileInfo.IsDir() {
+					continue
+				}
 				err = common.MoveFile(ctx, newTaskPath, w.recyclePath)
 				if err != nil {
 					log.Printf("[error] %s failed to move bad task to recycle bin: %s\n", w.GetPrettyName(), err.Error())
@@ -118,19 +143,27 @@ func (w *Worker) workerLoop(ctx context.Context) {
 				continue
 			}
 
-			newTask.TaskFile = newTaskPath
-
-			log.Printf("[info] %s load new task: %s\n", w.GetPrettyName(), newTaskPath)
-			status.SetStatusCode(newTask.Src, status.WAIT)
-			status.SetStatusDesc(newTask.Src, "waiting")
+			for _, newTask := range newTaskList {
+				if newTask.Batch != "" {
+					log.Printf("[info] %s load new task: %s: %s (batch %s)\n", w.GetPrettyName(), newTaskPath, newTask.Src, newTask.Batch)
+				} else {
+					log.Printf("[info] %s load new task: %s\n", w.GetPrettyName(), newTaskPath)
+				}
+				status.SetStatusBatch(newTask.Src, newTask.Batch)
+				status.SetStatusCode(newTask.Src, status.WAIT)
+				status.SetStatusDesc(newTask.Src, "waiting")
+			}
 
-			select {
-			case <-ctx.Done():
-				exitFlag = true
-				continue
-			case w.OutputStream <- *newTask:
-				continue
+		sendLoop:
+			for _, newTask := range newTaskList {
+				select {
+				case <-ctx.Done():
+					exitFlag = true
+					break sendLoop
+				case w.OutputStream <- *newTask:
+				}
 			}
+			continue
 		}
 
 		select {
@@ -152,8 +185,7 @@ func (w *Worker) checkNewTask(ctx context.Context) string {
 	var newTaskPath string
 	for _, fileInfo := range fileInfoList {
 		fileName := fileInfo.Name()
-		fileExt := filepath.Ext(fileName)
-		if fileExt == ".json" {
+		if !fileInfo.IsDir() && common.IsTaskFile(fileName) {
 			srcPath := filepath.Join(w.monitorPath, fileName)
 			dstPath := w.workDirPath
 			err = common.MoveFile(ctx, srcPath, dstPath)
@@ -165,7 +197,185 @@ func (w *Worker) checkNewTask(ctx context.Context) string {
 				continue
 			}
 		}
+
+		if fileInfo.IsDir() && fileName != filepath.Base(w.recyclePath) && !w.isScanned(fileName) {
+			dirPath := filepath.Join(w.monitorPath, fileName)
+			bdmvPath, found := bdmv.FindBdmvPath(dirPath)
+			if found && w.isSettled(bdmvPath) {
+				newTaskPath = dirPath
+				break
+			}
+		}
 	}
 
 	return newTaskPath
 }
+
+func (w *Worker) isScanned(dirName string) bool {
+	_, err := os.Stat(filepath.Join(w.recyclePath, dirName+".scanned"))
+	return err == nil
+}
+
+func (w *Worker) markScanned(dirName string) error {
+	scannedFile, err := os.Create(filepath.Join(w.recyclePath, dirName+".scanned"))
+	if err != nil {
+		return err
+	}
+	return scannedFile.Close()
+}
+
+func (w *Worker) isSettled(bdmvPath string) bool {
+	fileNum := 0
+	totalSize := int64(0)
+	err := filepath.Walk(bdmvPath, func(path string, fileInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fileInfo.IsDir() {
+			fileNum += 1
+			totalSize += fileInfo.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		delete(w.settleMap, bdmvPath)
+		return false
+	}
+
+	snapshot, exist := w.settleMap[bdmvPath]
+	if !exist || snapshot.fileNum != fileNum || snapshot.totalSize != totalSize {
+		w.settleMap[bdmvPath] = &bdmvSnapshot{
+			fileNum:   fileNum,
+			totalSize: totalSize,
+			time:      time.Now(),
+		}
+		return false
+	}
+
+	if time.Since(snapshot.time) < bdmvSettleTime {
+		return false
+	}
+
+	delete(w.settleMap, bdmvPath)
+	return true
+}
+
+func (w *Worker) loadTasks(ctx context.Context, taskPath string) ([]*common.Task, error) {
+	taskList, err := w.decodeTasks(ctx, taskPath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, task := range taskList {
+		if fileInfo, statErr := os.Stat(taskPath); statErr == nil && !fileInfo.IsDir() {
+			task.TaskFile = taskPath
+		}
+
+		if task.SourceInfo == nil {
+			sourceInfo, probeErr := probe.Probe(ctx, task.Src)
+			if probeErr != nil {
+				log.Printf("[error] %s failed to probe %s: %s\n", w.GetPrettyName(), task.Src, probeErr.Error())
+			} else {
+				task.SourceInfo = sourceInfo
+			}
+		}
+
+		err = task.ResolveTracks()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", task.Src, err.Error())
+		}
+
+		if _, exist := video.CodecHandlerMap[task.Video]; !exist {
+			return nil, fmt.Errorf("%s: unknown video codec: %s", task.Src, task.Video)
+		}
+
+		mux.ResolveDelayMode(task)
+		err = mux.ValidateTask(task)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", task.Src, err.Error())
+		}
+
+		err = task.ValidateOutput()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", task.Src, err.Error())
+		}
+
+		task.EffectiveFile, err = common.WriteEffectiveTask(task, w.workDirPath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", task.Src, err.Error())
+		}
+	}
+
+	return taskList, nil
+}
+
+func (w *Worker) decodeTasks(ctx context.Context, taskPath string) ([]*common.Task, error) {
+	fileInfo, err := os.Stat(taskPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if fileInfo.IsDir() {
+		taskList, err := bdmv.GenerateTasks(ctx, w.newBdmvRequest(taskPath), w.presetDirPath)
+		if err != nil {
+			return nil, err
+		}
+		err = w.markScanned(fileInfo.Name())
+		if err != nil {
+			return nil, errors.New("failed to mark bdmv folder as scanned: " + err.Error())
+		}
+		return taskList, nil
+	}
+
+	if bdmv.IsMarkerFile(taskPath) {
+		req, err := bdmv.NewRequestFromFile(taskPath)
+		if err != nil {
+			return nil, err
+		}
+		defaultReq := w.newBdmvRequest(req.Path)
+		if req.Preset == "" {
+			req.Preset = defaultReq.Preset
+		}
+		if len(req.AudioLanguages) <= 0 {
+			req.AudioLanguages = defaultReq.AudioLanguages
+		}
+		if len(req.SubtitleLanguages) <= 0 {
+			req.SubtitleLanguages = defaultReq.SubtitleLanguages
+		}
+		return bdmv.GenerateTasks(ctx, req, w.presetDirPath)
+	}
+
+	taskList, err := common.NewTasksFromFile(taskPath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, task := range taskList {
+		err = common.ApplyPreset(task, w.presetDirPath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", task.Src, err.Error())
+		}
+	}
+
+	return taskList, nil
+}
+
+func (w *Worker) newBdmvRequest(path string) *bdmv.Request {
+	return &bdmv.Request{
+		Path:              path,
+		Preset:            w.bdmvPreset,
+		AudioLanguages:    splitLanguages(w.bdmvAudioLangs),
+		SubtitleLanguages: splitLanguages(w.bdmvSubLangs),
+	}
+}
+
+func splitLanguages(s string) []string {
+	langList := make([]string, 0)
+	for _, lang := range strings.Split(s, ",") {
+		lang = strings.TrimSpace(lang)
+		if lang != "" {
+			langList = append(langList, lang)
+		}
+	}
+	return langList
+}
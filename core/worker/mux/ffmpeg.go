@@ -0,0 +1,196 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mux
+
+import (
+	"MonitorEncoder/core/chapter"
+	"MonitorEncoder/core/common"
+	"MonitorEncoder/core/report"
+	"MonitorEncoder/core/subtitle"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ffmpegTagMap = map[string]string{
+	"TITLE":            "title",
+	"ARTIST":           "artist",
+	"ALBUM":            "album",
+	"ALBUM_ARTIST":     "album_artist",
+	"COMPOSER":         "composer",
+	"GENRE":            "genre",
+	"COMMENT":          "comment",
+	"DATE_RELEASED":    "date",
+	"ENCODER_SETTINGS": "description",
+}
+
+var ffmpegMetadataReplacer = strings.NewReplacer("\\", "\\\\", "=", "\\=", ";", "\\;", "#", "\\#", "\n", "\\\n")
+
+func generateFFmpegHandler(format string) FormatHandler {
+	return func(ctx context.Context, outputPath string, task *common.Task, resultList []common.Result) error {
+		return muxFFmpeg(ctx, outputPath, task, resultList, format)
+	}
+}
+
+func muxFFmpeg(ctx context.Context, outputPath string, task *common.Task, resultList []common.Result, format string) error {
+	inputParam := []string{"-y"}
+	outputParam := make([]string, 0)
+	inputIndex := 0
+	streamIndexMap := map[string]int{"v": 0, "a": 0, "s": 0}
+	for _, result := range resultList {
+		var streamType string
+		switch result.Category {
+		case common.ResultVideo:
+			streamType = "v"
+			inputParam = append(inputParam, "-r", fmt.Sprintf("%d/%d", task.FPSNum, task.FPSDen))
+		case common.ResultAudio:
+			streamType = "a"
+			if result.Delay != 0 {
+				inputParam = append(inputParam, "-itsoffset", fmt.Sprintf("%.3f", float64(result.Delay)/1000))
+			}
+		case common.ResultSubtitle:
+			if !subtitle.IsTextSubtitle(result.Path) {
+				log.Printf("[warning] %s can not hold bitmap subtitle, skip %s\n", format, result.Path)
+				continue
+			}
+			streamType = "s"
+		case common.ResultChapters:
+			metadataPath, err := writeFFmpegChapters(task, result.Path)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				_ = common.DeleteFile(ctx, metadataPath)
+			}()
+
+			inputParam = append(inputParam, "-i", metadataPath)
+			outputParam = append(outputParam, "-map_chapters", fmt.Sprintf("%d", inputIndex))
+			inputIndex += 1
+			continue
+		case common.ResultAttachment:
+			log.Printf("[warning] %s can not hold attachments, skip %s\n", format, result.Path)
+			continue
+		default:
+			continue
+		}
+
+		inputParam = append(inputParam, "-i", result.Path)
+		outputParam = append(outputParam, "-map", fmt.Sprintf("%d:%s:0", inputIndex, streamType))
+		inputIndex += 1
+
+		streamSpec := fmt.Sprintf("%s:%d", streamType, streamIndexMap[streamType])
+		streamIndexMap[streamType] += 1
+		if result.Lang != "" {
+			outputParam = append(outputParam, "-metadata:s:"+streamSpec, "language="+result.Lang)
+		}
+		if result.Name != "" {
+			outputParam = append(outputParam, "-metadata:s:"+streamSpec, "handler_name="+result.Name)
+		}
+		if disposition := ffmpegDisposition(result, streamType); disposition != "" {
+			outputParam = append(outputParam, "-disposition:"+streamSpec, disposition)
+		}
+	}
+
+	if task.HasMetadata() {
+		tagMap := task.GetMetadata()
+		nameList := make([]string, 0, len(tagMap))
+		for name := range tagMap {
+			nameList = append(nameList, name)
+		}
+		sort.Strings(nameList)
+
+		for _, name := range nameList {
+			ffmpegName, exist := ffmpegTagMap[name]
+			if !exist {
+				log.Printf("[warning] %s has no tag for %s, skip it\n", format, name)
+				continue
+			}
+			outputParam = append(outputParam, "-metadata", ffmpegName+"="+tagMap[name])
+		}
+	}
+
+	outputParam = append(outputParam, "-c", "copy", "-c:s", "mov_text", "-f", format, outputPath)
+
+	ffmpegPath := common.GetFFmpegPath()
+	ffmpegProcess := report.NewCommand(ctx, ffmpegPath, append(inputParam, outputParam...)...)
+	return ffmpegProcess.Run()
+}
+
+func ffmpegDisposition(result common.Result, streamType string) string {
+	dispositionList := make([]string, 0)
+	if result.Default != nil && *result.Default {
+		dispositionList = append(dispositionList, "default")
+	}
+	if result.Forced {
+		if streamType == "s" {
+			dispositionList = append(dispositionList, "forced")
+		} else {
+			log.Printf("[warning] ffmpeg muxer can not set forced flag on %s\n", result.Path)
+		}
+	}
+
+	if len(dispositionList) <= 0 {
+		if result.Default != nil {
+			return "0"
+		}
+		return ""
+	}
+	return strings.Join(dispositionList, "+")
+}
+
+func writeFFmpegChapters(task *common.Task, chapterPath string) (string, error) {
+	chapterList, err := chapter.Read(chapterPath)
+	if err != nil {
+		return "", err
+	}
+
+	var duration time.Duration
+	if task.TotalFrameNum > 0 && task.FPSNum > 0 && task.FPSDen > 0 {
+		duration = time.Duration(int64(task.TotalFrameNum) * int64(task.FPSDen) * int64(time.Second) / int64(task.FPSNum))
+	}
+
+	var builder strings.Builder
+	builder.WriteString(";FFMETADATA1\n")
+	for i, c := range chapterList {
+		end := duration
+		if i+1 < len(chapterList) {
+			end = chapterList[i+1].Start
+		}
+		if end <= c.Start {
+			end = c.Start + time.Millisecond
+		}
+
+		builder.WriteString("[CHAPTER]\nTIMEBASE=1/1000\n")
+		builder.WriteString(fmt.Sprintf("START=%d\nEND=%d\n", c.Start.Milliseconds(), end.Milliseconds()))
+		builder.WriteString("title=" + ffmpegMetadataReplacer.Replace(c.Name) + "\n")
+	}
+
+	metadataPath := strings.TrimSuffix(chapterPath, ".txt") + ".ffmetadata"
+	err = ioutil.WriteFile(metadataPath, []byte(builder.String()), 0644)
+	if err != nil {
+		return "", errors.New("failed to write ffmpeg chapters: " + err.Error())
+	}
+
+	return metadataPath, nil
+}
@@ -0,0 +1,105 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mux
+
+import (
+	"MonitorEncoder/core/common"
+	"MonitorEncoder/core/font"
+	"MonitorEncoder/core/status"
+	"MonitorEncoder/core/subtitle"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func collectFonts(task *common.Task, resultList []common.Result) ([]string, error) {
+	fontSet := make(map[string]bool)
+	fontList := make([]string, 0)
+	addFont := func(path string) {
+		key := strings.ToLower(filepath.Clean(path))
+		if !fontSet[key] {
+			fontSet[key] = true
+			fontList = append(fontList, path)
+		}
+	}
+
+	for _, path := range task.Fonts {
+		fileInfo, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("font not exist: %s", path)
+		}
+
+		if !fileInfo.IsDir() {
+			addFont(path)
+			continue
+		}
+
+		dirFontList, err := font.ListFontFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, dirFont := range dirFontList {
+			addFont(dirFont)
+		}
+	}
+
+	if task.FontDir == "" {
+		return fontList, nil
+	}
+
+	if fileInfo, err := os.Stat(task.FontDir); err != nil || !fileInfo.IsDir() {
+		return nil, errors.New("font dir not exist: " + task.FontDir)
+	}
+
+	fontIndex, err := font.BuildIndex(task.FontDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, result := range resultList {
+		if result.Category != common.ResultSubtitle || !isAssFile(result.Path) {
+			continue
+		}
+
+		ass, err := subtitle.ReadAss(result.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, fontName := range ass.FontNames() {
+			path, found := fontIndex[strings.ToLower(fontName)]
+			if !found {
+				log.Printf("[warning] font %q used by %s not found in %s\n", fontName, result.Path, task.FontDir)
+				status.AddStatusReport(task.Src, fmt.Sprintf("missing font: %s", fontName))
+				continue
+			}
+			addFont(path)
+		}
+	}
+
+	return fontList, nil
+}
+
+func isAssFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".ass" || ext == ".ssa"
+}
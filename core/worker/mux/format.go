@@ -0,0 +1,297 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mux
+
+import (
+	"MonitorEncoder/core/common"
+	"MonitorEncoder/core/subtitle"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+)
+
+type Format struct {
+	Ext                string
+	Handler            FormatHandler
+	VideoCodecList     []string
+	AudioCodecList     []string
+	SubtitleFormatList []string
+	NegativeDelayOnly  bool
+	ToolPath           func() string
+	TagToolPath        func() string
+}
+
+var formatMap = map[string]*Format{
+	"mkv": {
+		Ext:            "mkv",
+		Handler:        generateMatroskaHandler(false),
+		VideoCodecList: []string{"hevc", "avc"},
+		ToolPath:       common.GetMkvmergePath,
+	},
+	"webm": {
+		Ext:                "webm",
+		Handler:            generateMatroskaHandler(true),
+		VideoCodecList:     []string{"av1", "vp9"},
+		AudioCodecList:     []string{"opus"},
+		SubtitleFormatList: []string{},
+		ToolPath:           common.GetMkvmergePath,
+	},
+	"mp4": {
+		Ext:                "mp4",
+		Handler:            handlerLsmash,
+		VideoCodecList:     []string{"hevc", "avc"},
+		AudioCodecList:     []string{"aac", "ac3", "eac3", "dts"},
+		SubtitleFormatList: []string{"srt", "ass"},
+		NegativeDelayOnly:  true,
+		ToolPath:           common.GetLsmashPath,
+		TagToolPath:        common.GetMp4boxPath,
+	},
+	"mp4-mp4box": {
+		Ext:                "mp4",
+		Handler:            handlerMp4box,
+		VideoCodecList:     []string{"hevc", "avc"},
+		AudioCodecList:     []string{"aac", "ac3", "eac3", "opus", "flac"},
+		SubtitleFormatList: []string{"srt", "ass"},
+		ToolPath:           common.GetMp4boxPath,
+		TagToolPath:        common.GetMp4boxPath,
+	},
+	"mp4-ffmpeg": {
+		Ext:                "mp4",
+		Handler:            generateFFmpegHandler("mp4"),
+		VideoCodecList:     []string{"hevc", "avc"},
+		AudioCodecList:     []string{"aac", "ac3", "eac3", "opus", "flac"},
+		SubtitleFormatList: []string{"srt", "ass"},
+		ToolPath:           common.GetFFmpegPath,
+	},
+	"mov": {
+		Ext:                "mov",
+		Handler:            generateFFmpegHandler("mov"),
+		VideoCodecList:     []string{"hevc", "avc"},
+		AudioCodecList:     []string{"aac", "ac3", "eac3", "wav"},
+		SubtitleFormatList: []string{"srt", "ass"},
+		ToolPath:           common.GetFFmpegPath,
+	},
+}
+
+type trackEntry struct {
+	category common.ResultCategory
+	lang     string
+	codec    string
+	track    uint
+}
+
+func containsCodec(codecList []string, codec string) bool {
+	if codecList == nil {
+		return true
+	}
+
+	for _, c := range codecList {
+		if c == codec {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidateTask(task *common.Task) error {
+	entryList := []trackEntry{{category: common.ResultVideo, codec: common.CodecFamily(task.Video)}}
+	for _, audioTask := range task.Audio {
+		entryList = append(entryList, trackEntry{
+			category: common.ResultAudio,
+			lang:     audioTask.Language,
+			codec:    common.CodecFamily(audioTask.Codec),
+			track:    audioTask.Track,
+		})
+	}
+	for _, demuxTask := range task.Demux {
+		outputFormat := demuxTask.Format
+		if demuxTask.Convert != "" {
+			outputFormat = demuxTask.Convert
+		}
+		outputFormat = common.CodecFamily(outputFormat)
+
+		category := common.ResultAudio
+		if outputFormat == "txt" || outputFormat == "xml" {
+			category = common.ResultChapters
+		} else if subtitle.IsSubtitle("." + outputFormat) {
+			category = common.ResultSubtitle
+		}
+
+		entryList = append(entryList, trackEntry{
+			category: category,
+			lang:     demuxTask.Language,
+			codec:    outputFormat,
+			track:    demuxTask.Track,
+		})
+	}
+
+	for _, spec := range task.Mux {
+		err := validateMuxSpec(&spec, entryList, task.HasMetadata())
+		if err != nil {
+			return err
+		}
+	}
+
+	return validateDelayMode(task)
+}
+
+func ResolveDelayMode(task *common.Task) {
+	for i := range task.Audio {
+		audioTask := &task.Audio[i]
+		if audioTask.DelayMode != "" {
+			continue
+		}
+
+		format := findNegativeDelayOnlyFormat(task, audioTask)
+		if format != "" {
+			audioTask.DelayMode = common.DelayModeEac3to
+			log.Printf("[info] %s: %s can not delay audio track %d by %dms, delay it with eac3to\n", task.Src, format, audioTask.Track, audioDelay(task, audioTask))
+		}
+	}
+}
+
+func validateDelayMode(task *common.Task) error {
+	for i := range task.Audio {
+		audioTask := &task.Audio[i]
+		if audioTask.DelayMode != "" && audioTask.DelayMode != common.DelayModeMux {
+			continue
+		}
+
+		format := findNegativeDelayOnlyFormat(task, audioTask)
+		if format != "" {
+			return fmt.Errorf("%s can not delay audio track %d by %dms, use delay_mode eac3to", format, audioTask.Track, audioDelay(task, audioTask))
+		}
+	}
+
+	return nil
+}
+
+func findNegativeDelayOnlyFormat(task *common.Task, audioTask *common.AudioTask) string {
+	if audioDelay(task, audioTask) <= 0 {
+		return ""
+	}
+
+	for _, spec := range task.Mux {
+		format, exist := formatMap[spec.Format]
+		if !exist || !format.NegativeDelayOnly {
+			continue
+		}
+		if spec.Match(common.ResultAudio, audioTask.Language, common.CodecFamily(audioTask.Codec)) {
+			return spec.Format
+		}
+	}
+
+	return ""
+}
+
+func audioDelay(task *common.Task, audioTask *common.AudioTask) int {
+	if task.SourceInfo == nil {
+		return 0
+	}
+
+	trackInfo, found := task.SourceInfo.GetTrack(audioTask.Track)
+	if !found {
+		return 0
+	}
+	return trackInfo.Delay
+}
+
+func validateMuxSpec(spec *common.MuxSpec, entryList []trackEntry, hasMetadata bool) error {
+	format, exist := formatMap[spec.Format]
+	if !exist {
+		return errors.New("unknown mux format: " + spec.Format)
+	}
+
+	err := spec.Validate()
+	if err != nil {
+		return err
+	}
+
+	if !common.IsToolAvailable(format.ToolPath()) {
+		return fmt.Errorf("%s needs %s, which is not available", spec.Format, format.ToolPath())
+	}
+
+	needTagTool := hasMetadata
+	for _, entry := range entryList {
+		if !spec.Match(entry.category, entry.lang, entry.codec) {
+			continue
+		}
+
+		switch entry.category {
+		case common.ResultVideo:
+			if !containsCodec(format.VideoCodecList, entry.codec) {
+				return fmt.Errorf("%s does not accept video codec %s", spec.Format, entry.codec)
+			}
+		case common.ResultAudio:
+			if !containsCodec(format.AudioCodecList, entry.codec) {
+				return fmt.Errorf("%s does not accept audio codec %s (track %d)", spec.Format, entry.codec, entry.track)
+			}
+		case common.ResultSubtitle:
+			if !containsCodec(format.SubtitleFormatList, entry.codec) {
+				return fmt.Errorf("%s does not accept subtitle format %s (track %d)", spec.Format, entry.codec, entry.track)
+			}
+			if subtitle.IsTextSubtitle("." + entry.codec) {
+				needTagTool = true
+			}
+		}
+	}
+
+	if needTagTool && format.TagToolPath != nil && !common.IsToolAvailable(format.TagToolPath()) {
+		return fmt.Errorf("%s needs %s for text subtitles and metadata, which is not available", spec.Format, format.TagToolPath())
+	}
+
+	return nil
+}
+
+func resultCodec(task *common.Task, result common.Result) string {
+	if result.Category == common.ResultVideo {
+		return common.CodecFamily(task.Video)
+	}
+	return common.CodecFamily(filepath.Ext(result.Path))
+}
+
+func attachmentResultList(task *common.Task, resultList []common.Result) ([]common.Result, error) {
+	pathList, err := collectFonts(task, resultList)
+	if err != nil {
+		return nil, err
+	}
+	if task.AttachScript {
+		pathList = append(pathList, task.ScriptFile)
+	}
+	if task.AttachTask {
+		pathList = append(pathList, task.EffectiveFile)
+	}
+
+	attachmentList := make([]common.Result, 0, len(pathList))
+	for _, path := range pathList {
+		attachmentList = append(attachmentList, common.NewResult(path, common.ResultAttachment, "", 0))
+	}
+	return attachmentList, nil
+}
+
+func filterResultList(task *common.Task, spec *common.MuxSpec) []common.Result {
+	resultList := make([]common.Result, 0)
+	for _, result := range task.GetResultList() {
+		if spec.Match(result.Category, result.Lang, resultCodec(task, result)) {
+			resultList = append(resultList, result)
+		}
+	}
+	return resultList
+}
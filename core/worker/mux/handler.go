@@ -20,66 +20,294 @@ package mux
 
 import (
 	"MonitorEncoder/core/common"
+	"MonitorEncoder/core/font"
+	"MonitorEncoder/core/report"
+	"MonitorEncoder/core/subtitle"
 	"context"
+	"errors"
 	"fmt"
-	"os/exec"
+	"io/ioutil"
+	"log"
+	"mime"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
-type FormatHandler func(context.Context, string, *common.Task) (string, error)
+type FormatHandler func(context.Context, string, *common.Task, []common.Result) error
 
-var formatHandlerMap = map[string]FormatHandler{
-	"mkv": handlerMkv,
-	"mp4": handlerMp4,
+var itunesTagMap = map[string]string{
+	"TITLE":            "name",
+	"ARTIST":           "artist",
+	"ALBUM":            "album",
+	"ALBUM_ARTIST":     "album_artist",
+	"COMPOSER":         "composer",
+	"GENRE":            "genre",
+	"COMMENT":          "comment",
+	"DATE_RELEASED":    "created",
+	"ENCODER_SETTINGS": "tool",
 }
 
-func handlerMkv(ctx context.Context, workDirPath string, task *common.Task) (string, error) {
-	mkvFilePath := common.GenerateNewFilePath(task.Src, workDirPath, "mkv", "", 0)
+var attachmentMimeTypeMap = map[string]string{
+	".vpy":  "text/x-python",
+	".json": "application/json",
+}
 
-	mkvmergeParam := []string{"-o", mkvFilePath}
-	resultList := task.GetResultList()
+func generateMatroskaHandler(isWebm bool) FormatHandler {
+	return func(ctx context.Context, outputPath string, task *common.Task, resultList []common.Result) error {
+		return muxMatroska(ctx, outputPath, task, resultList, isWebm)
+	}
+}
+
+func muxMatroska(ctx context.Context, outputPath string, task *common.Task, resultList []common.Result, isWebm bool) error {
+	mkvmergeParam := []string{"-o", outputPath}
+	if isWebm {
+		mkvmergeParam = append(mkvmergeParam, "--webm")
+	}
+	if title, exist := task.GetMetadata()["TITLE"]; exist {
+		mkvmergeParam = append(mkvmergeParam, "--title", title)
+	}
 	for _, result := range resultList {
+		switch result.Category {
+		case common.ResultChapters:
+			mkvmergeParam = append(mkvmergeParam, "--chapters", result.Path)
+			continue
+		case common.ResultAttachment, common.ResultTags:
+			if isWebm {
+				log.Printf("[warning] webm can not hold attachments or tag files, skip %s\n", result.Path)
+			} else if result.Category == common.ResultAttachment {
+				mkvmergeParam = append(mkvmergeParam, attachmentParam(result.Path)...)
+			} else {
+				mkvmergeParam = append(mkvmergeParam, "--global-tags", result.Path)
+			}
+			continue
+		}
+
 		if result.Lang != "" {
 			mkvmergeParam = append(mkvmergeParam, "--language")
 			mkvmergeParam = append(mkvmergeParam, fmt.Sprintf("0:%s", result.Lang))
 		}
+		if result.Name != "" {
+			mkvmergeParam = append(mkvmergeParam, "--track-name")
+			mkvmergeParam = append(mkvmergeParam, fmt.Sprintf("0:%s", result.Name))
+		}
+		if result.Default != nil {
+			mkvmergeParam = append(mkvmergeParam, "--default-track-flag")
+			mkvmergeParam = append(mkvmergeParam, fmt.Sprintf("0:%s", yesNo(*result.Default)))
+		}
+		if result.Forced {
+			mkvmergeParam = append(mkvmergeParam, "--forced-display-flag")
+			mkvmergeParam = append(mkvmergeParam, "0:yes")
+		}
+		if result.Delay != 0 {
+			mkvmergeParam = append(mkvmergeParam, "--sync")
+			mkvmergeParam = append(mkvmergeParam, fmt.Sprintf("0:%d", result.Delay))
+		}
 		mkvmergeParam = append(mkvmergeParam, result.Path)
 	}
 
 	mkvmergePath := common.GetMkvmergePath()
-	mkvmergeProcess := exec.CommandContext(ctx, mkvmergePath, mkvmergeParam...)
-	err := mkvmergeProcess.Run()
-	if err != nil {
-		return "", err
-	}
-
-	return mkvFilePath, nil
+	mkvmergeProcess := report.NewCommand(ctx, mkvmergePath, mkvmergeParam...)
+	return mkvmergeProcess.Run()
 }
 
-func handlerMp4(ctx context.Context, workDirPath string, task *common.Task) (string, error) {
-	mp4FilePath := common.GenerateNewFilePath(task.Src, workDirPath, "mp4", "", 0)
-
+func handlerLsmash(ctx context.Context, mp4FilePath string, task *common.Task, resultList []common.Result) error {
 	lsmashParam := []string{"-o", mp4FilePath}
-	resultList := task.GetResultList()
 	for _, result := range resultList {
-		if result.Category == common.ResultVideo {
+		switch result.Category {
+		case common.ResultVideo:
+			trackOptList := []string{fmt.Sprintf("fps=%d/%d", task.FPSNum, task.FPSDen)}
+			trackOptList = append(trackOptList, lsmashFlagOptList(result)...)
 			lsmashParam = append(lsmashParam, "-i")
-			lsmashParam = append(lsmashParam, fmt.Sprintf("%s?fps=%d/%d", result.Path, task.FPSNum, task.FPSDen))
-		} else {
+			lsmashParam = append(lsmashParam, result.Path+"?"+strings.Join(trackOptList, ","))
+		case common.ResultChapters:
+			if strings.EqualFold(filepath.Ext(result.Path), ".xml") {
+				log.Printf("[warning] mp4 muxer only accepts ogm chapters, skip %s\n", result.Path)
+				continue
+			}
+			lsmashParam = append(lsmashParam, "--chapter", result.Path)
+		case common.ResultSubtitle:
+			continue
+		case common.ResultAttachment, common.ResultTags:
+			log.Printf("[warning] mp4 can not hold attachments or tag files, skip %s\n", result.Path)
+		default:
 			lsmashParam = append(lsmashParam, "-i")
-			trackOpts := result.Path
+			trackOptList := make([]string, 0)
 			if result.Lang != "" {
-				trackOpts += fmt.Sprintf("?language=%s", result.Lang)
+				trackOptList = append(trackOptList, fmt.Sprintf("language=%s", result.Lang))
+			}
+			if result.Delay < 0 {
+				trackOptList = append(trackOptList, fmt.Sprintf("encoder-delay=%d", delayToSamples(task, result)))
+			} else if result.Delay > 0 {
+				return fmt.Errorf("mp4 muxer can not delay track #%d by %dms", result.Track, result.Delay)
+			}
+			trackOptList = append(trackOptList, lsmashFlagOptList(result)...)
+			trackOpts := result.Path
+			if len(trackOptList) > 0 {
+				trackOpts += "?" + strings.Join(trackOptList, ",")
 			}
 			lsmashParam = append(lsmashParam, trackOpts)
 		}
 	}
 
 	lsmashPath := common.GetLsmashPath()
-	lsmashProcess := exec.CommandContext(ctx, lsmashPath, lsmashParam...)
+	lsmashProcess := report.NewCommand(ctx, lsmashPath, lsmashParam...)
 	err := lsmashProcess.Run()
 	if err != nil {
-		return "", err
+		return err
+	}
+
+	err = addMp4Subtitles(ctx, mp4FilePath, resultList)
+	if err != nil {
+		return err
+	}
+
+	if task.HasMetadata() {
+		return addItunesTags(ctx, mp4FilePath, task.GetMetadata())
+	}
+
+	return nil
+}
+
+func addMp4Subtitles(ctx context.Context, mp4FilePath string, resultList []common.Result) error {
+	for _, result := range resultList {
+		if result.Category != common.ResultSubtitle {
+			continue
+		}
+		if !subtitle.IsTextSubtitle(result.Path) {
+			log.Printf("[warning] mp4 can not hold bitmap subtitle, skip %s\n", result.Path)
+			continue
+		}
+
+		err := addTx3gSubtitle(ctx, mp4FilePath, result)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	return mp4FilePath, nil
+func addTx3gSubtitle(ctx context.Context, mp4FilePath string, result common.Result) error {
+	srtPath := result.Path
+	if !strings.EqualFold(filepath.Ext(srtPath), ".srt") {
+		ass, err := subtitle.ReadAss(result.Path)
+		if err != nil {
+			return err
+		}
+
+		srtPath = strings.TrimSuffix(result.Path, filepath.Ext(result.Path)) + ".tx3g.srt"
+		err = subtitle.WriteSrt(srtPath, ass.ToCues())
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = common.DeleteFile(ctx, srtPath)
+		}()
+	}
+
+	trackOpts := srtPath + ":hdlr=sbtl"
+	if result.Lang != "" {
+		trackOpts += ":lang=" + result.Lang
+	}
+	trackOpts += mp4boxFlagOpts(result)
+	if result.Forced {
+		trackOpts += ":txtflags=0xC0000000"
+	}
+
+	mp4boxPath := common.GetMp4boxPath()
+	mp4boxProcess := report.NewCommand(ctx, mp4boxPath, "-add", trackOpts, mp4FilePath)
+	return mp4boxProcess.Run()
+}
+
+func addItunesTags(ctx context.Context, mp4FilePath string, tagMap map[string]string) error {
+	tagsData := itunesTagsData(tagMap)
+	if tagsData == "" {
+		return nil
+	}
+
+	tagsPath := strings.TrimSuffix(mp4FilePath, filepath.Ext(mp4FilePath)) + ".itags.txt"
+	err := ioutil.WriteFile(tagsPath, []byte(tagsData), 0644)
+	if err != nil {
+		return errors.New("failed to write itunes tags: " + err.Error())
+	}
+	defer func() {
+		_ = common.DeleteFile(ctx, tagsPath)
+	}()
+
+	mp4boxPath := common.GetMp4boxPath()
+	mp4boxProcess := report.NewCommand(ctx, mp4boxPath, itunesTagsParam(tagsPath, mp4FilePath)...)
+	return mp4boxProcess.Run()
+}
+
+func itunesTagsParam(tagsPath string, mp4FilePath string) []string {
+	return []string{"-itags", tagsPath, mp4FilePath}
+}
+
+func itunesTagsData(tagMap map[string]string) string {
+	nameList := make([]string, 0, len(tagMap))
+	for name := range tagMap {
+		nameList = append(nameList, name)
+	}
+	sort.Strings(nameList)
+
+	var builder strings.Builder
+	for _, name := range nameList {
+		itunesName, exist := itunesTagMap[name]
+		if !exist {
+			log.Printf("[warning] mp4 has no itunes tag for %s, skip it\n", name)
+			continue
+		}
+		value := strings.Join(strings.Fields(strings.NewReplacer("\r", " ", "\n", " ").Replace(tagMap[name])), " ")
+		builder.WriteString(itunesName + "=" + value + "\n")
+	}
+
+	return builder.String()
+}
+
+func attachmentParam(path string) []string {
+	mimeType := font.MimeType(path)
+	if mimeType == "" {
+		mimeType = attachmentMimeTypeMap[strings.ToLower(filepath.Ext(path))]
+	}
+	if mimeType == "" {
+		mimeType = mime.TypeByExtension(filepath.Ext(path))
+	}
+
+	if mimeType == "" {
+		return []string{"--attach-file", path}
+	}
+	return []string{"--attachment-mime-type", mimeType, "--attach-file", path}
+}
+
+func lsmashFlagOptList(result common.Result) []string {
+	optList := make([]string, 0)
+	if result.Name != "" {
+		optList = append(optList, "handler="+result.Name)
+	}
+	if result.Default != nil && !*result.Default {
+		optList = append(optList, "disable")
+	}
+	if result.Forced {
+		log.Printf("[warning] mp4 muxer can not set forced flag on %s\n", result.Path)
+	}
+	return optList
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func delayToSamples(task *common.Task, result common.Result) int {
+	sampleRate := uint(48000)
+	if result.SampleRate > 0 {
+		sampleRate = result.SampleRate
+	} else if task.SourceInfo != nil {
+		if trackInfo, found := task.SourceInfo.GetTrack(result.Track); found && trackInfo.SampleRate > 0 {
+			sampleRate = trackInfo.SampleRate
+		}
+	}
+	return -result.Delay * int(sampleRate) / 1000
 }
@@ -0,0 +1,73 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mux
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestItunesTagsData(t *testing.T) {
+	testList := []struct {
+		name   string
+		tagMap map[string]string
+		expect string
+	}{
+		{
+			name:   "empty",
+			tagMap: map[string]string{},
+			expect: "",
+		},
+		{
+			name:   "sorted by tag name",
+			tagMap: map[string]string{"TITLE": "Episode 1", "ARTIST": "Someone"},
+			expect: "artist=Someone\nname=Episode 1\n",
+		},
+		{
+			name:   "colon in value",
+			tagMap: map[string]string{"TITLE": "va:lue", "COMMENT": "a:comment=b"},
+			expect: "comment=a:comment=b\nname=va:lue\n",
+		},
+		{
+			name:   "line break in value",
+			tagMap: map[string]string{"COMMENT": "first line\r\nsecond line\n"},
+			expect: "comment=first line second line\n",
+		},
+		{
+			name:   "unknown tag",
+			tagMap: map[string]string{"TITLE": "x", "SHOW": "y"},
+			expect: "name=x\n",
+		},
+	}
+
+	for _, test := range testList {
+		data := itunesTagsData(test.tagMap)
+		if data != test.expect {
+			t.Errorf("%s: got %q, expect %q", test.name, data, test.expect)
+		}
+	}
+}
+
+func TestItunesTagsParam(t *testing.T) {
+	param := itunesTagsParam("work/a.itags.txt", "work/a.mp4")
+	expect := []string{"-itags", "work/a.itags.txt", "work/a.mp4"}
+	if !reflect.DeepEqual(param, expect) {
+		t.Errorf("got %q, expect %q", param, expect)
+	}
+}
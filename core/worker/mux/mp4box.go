@@ -0,0 +1,87 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mux
+
+import (
+	"MonitorEncoder/core/common"
+	"MonitorEncoder/core/report"
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+func handlerMp4box(ctx context.Context, mp4FilePath string, task *common.Task, resultList []common.Result) error {
+	mp4boxParam := make([]string, 0)
+	for _, result := range resultList {
+		switch result.Category {
+		case common.ResultVideo:
+			trackOpts := result.Path + fmt.Sprintf(":fps=%d/%d", task.FPSNum, task.FPSDen)
+			mp4boxParam = append(mp4boxParam, "-add", trackOpts+mp4boxFlagOpts(result))
+		case common.ResultAudio:
+			trackOpts := result.Path
+			if result.Lang != "" {
+				trackOpts += ":lang=" + result.Lang
+			}
+			if result.Delay != 0 {
+				trackOpts += fmt.Sprintf(":delay=%d", result.Delay)
+			}
+			mp4boxParam = append(mp4boxParam, "-add", trackOpts+mp4boxFlagOpts(result))
+		case common.ResultChapters:
+			if strings.EqualFold(filepath.Ext(result.Path), ".xml") {
+				log.Printf("[warning] mp4 muxer only accepts ogm chapters, skip %s\n", result.Path)
+				continue
+			}
+			mp4boxParam = append(mp4boxParam, "-chap", result.Path)
+		case common.ResultAttachment, common.ResultTags:
+			log.Printf("[warning] mp4 can not hold attachments or tag files, skip %s\n", result.Path)
+		}
+	}
+	mp4boxParam = append(mp4boxParam, "-new", mp4FilePath)
+
+	mp4boxPath := common.GetMp4boxPath()
+	mp4boxProcess := report.NewCommand(ctx, mp4boxPath, mp4boxParam...)
+	err := mp4boxProcess.Run()
+	if err != nil {
+		return err
+	}
+
+	err = addMp4Subtitles(ctx, mp4FilePath, resultList)
+	if err != nil {
+		return err
+	}
+
+	if task.HasMetadata() {
+		return addItunesTags(ctx, mp4FilePath, task.GetMetadata())
+	}
+
+	return nil
+}
+
+func mp4boxFlagOpts(result common.Result) string {
+	opts := ""
+	if result.Name != "" {
+		opts += ":name=" + result.Name
+	}
+	if result.Default != nil && !*result.Default {
+		opts += ":disable"
+	}
+	return opts
+}
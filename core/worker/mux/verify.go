@@ -0,0 +1,187 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mux
+
+import (
+	"MonitorEncoder/core/common"
+	"MonitorEncoder/core/report"
+	"MonitorEncoder/core/subtitle"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type identifyResult struct {
+	Container struct {
+		Recognized bool `json:"recognized"`
+		Properties struct {
+			Duration int64 `json:"duration"`
+		} `json:"properties"`
+	} `json:"container"`
+	TrackList []identifyTrack `json:"tracks"`
+}
+
+type identifyTrack struct {
+	Codec      string `json:"codec"`
+	Type       string `json:"type"`
+	Properties struct {
+		Language     string `json:"language"`
+		LanguageIetf string `json:"language_ietf"`
+	} `json:"properties"`
+}
+
+var codecNameMap = map[string][]string{
+	".hevc": {"hevc"},
+	".264":  {"avc"},
+	".flac": {"flac"},
+	".opus": {"opus"},
+	".aac":  {"aac"},
+	".m4a":  {"aac"},
+	".ac3":  {"ac-3"},
+	".eac3": {"e-ac-3"},
+	".dts":  {"dts", "dts-es", "dts-hd high resolution audio", "dts-hd master audio"},
+	".thd":  {"truehd", "truehd atmos"},
+	".wav":  {"pcm"},
+	".sup":  {"hdmv pgs"},
+	".srt":  {"subrip"},
+	".ass":  {"substationalpha"},
+	".ssa":  {"substationalpha"},
+}
+
+var isoTextCodecNameList = []string{"timed text"}
+
+func verifyOutput(ctx context.Context, task *common.Task, outputPath string, resultList []common.Result) (string, error) {
+	mkvmergePath := common.GetMkvmergePath()
+	mkvmergeProcess := report.NewCommand(ctx, mkvmergePath, "-J", outputPath)
+	data, err := mkvmergeProcess.Output()
+	if len(data) <= 0 && err != nil {
+		return "", errors.New("failed to identify output: " + err.Error())
+	}
+
+	var identify identifyResult
+	err = json.Unmarshal(data, &identify)
+	if err != nil {
+		return "", errors.New("failed to parse output identification: " + err.Error())
+	}
+	if !identify.Container.Recognized {
+		return "", errors.New("output container not recognized")
+	}
+
+	trackList := identify.TrackList
+	expectedList := expectedResultList(resultList, outputPath)
+	if len(trackList) != len(expectedList) {
+		return "", fmt.Errorf("output has %d tracks, expect %d", len(trackList), len(expectedList))
+	}
+
+	matchedSet := make(map[int]bool)
+	for _, result := range expectedList {
+		index := matchTrack(trackList, matchedSet, result, isIsoOutput(outputPath))
+		if index < 0 {
+			return "", fmt.Errorf("no track in output matches %s", filepath.Base(result.Path))
+		}
+		matchedSet[index] = true
+	}
+
+	duration := time.Duration(identify.Container.Properties.Duration)
+	if task.TotalFrameNum > 0 && task.FPSNum > 0 && task.FPSDen > 0 {
+		expected := time.Duration(int64(task.TotalFrameNum) * int64(task.FPSDen) * int64(time.Second) / int64(task.FPSNum))
+		tolerance := expected / 100
+		if tolerance < time.Second {
+			tolerance = time.Second
+		}
+
+		diff := duration - expected
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff > tolerance {
+			return "", fmt.Errorf("output duration %s differs from video duration %s", duration.Round(time.Millisecond), expected.Round(time.Millisecond))
+		}
+	}
+
+	return fmt.Sprintf("%s verified: %d tracks, %s", filepath.Base(outputPath), len(identify.TrackList), duration.Round(time.Millisecond)), nil
+}
+
+func expectedResultList(resultList []common.Result, outputPath string) []common.Result {
+	expectedList := make([]common.Result, 0)
+	for _, result := range resultList {
+		switch result.Category {
+		case common.ResultVideo, common.ResultAudio:
+		case common.ResultSubtitle:
+			if isIsoOutput(outputPath) && !subtitle.IsTextSubtitle(result.Path) {
+				continue
+			}
+		default:
+			continue
+		}
+		expectedList = append(expectedList, result)
+	}
+	return expectedList
+}
+
+func matchTrack(trackList []identifyTrack, matchedSet map[int]bool, result common.Result, isIso bool) int {
+	trackType := "audio"
+	switch result.Category {
+	case common.ResultVideo:
+		trackType = "video"
+	case common.ResultSubtitle:
+		trackType = "subtitles"
+	}
+
+	codecNameList := codecNameMap[strings.ToLower(filepath.Ext(result.Path))]
+	if isIso && result.Category == common.ResultSubtitle {
+		codecNameList = isoTextCodecNameList
+	}
+	for i, track := range trackList {
+		if matchedSet[i] || track.Type != trackType {
+			continue
+		}
+		if codecNameList != nil && !matchCodec(track.Codec, codecNameList) {
+			continue
+		}
+		if result.Lang != "" && result.Lang != "und" &&
+			!strings.EqualFold(track.Properties.Language, result.Lang) &&
+			!strings.EqualFold(track.Properties.LanguageIetf, result.Lang) {
+			continue
+		}
+		return i
+	}
+
+	return -1
+}
+
+func isIsoOutput(outputPath string) bool {
+	ext := strings.ToLower(filepath.Ext(outputPath))
+	return ext == ".mp4" || ext == ".mov"
+}
+
+func matchCodec(codec string, codecNameList []string) bool {
+	for _, part := range strings.Split(strings.ToLower(codec), "/") {
+		for _, codecName := range codecNameList {
+			if strings.TrimSpace(part) == codecName {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mux
+
+import (
+	"MonitorEncoder/core/common"
+	"testing"
+)
+
+func TestMatchTrack(t *testing.T) {
+	trackList := []identifyTrack{
+		{Codec: "HEVC/H.265/MPEG-H", Type: "video"},
+		{Codec: "E-AC-3", Type: "audio"},
+		{Codec: "AC-3", Type: "audio"},
+		{Codec: "Timed Text", Type: "subtitles"},
+	}
+
+	testList := []struct {
+		name   string
+		result common.Result
+		isIso  bool
+		expect int
+	}{
+		{name: "video", result: common.Result{Path: "a.hevc", Category: common.ResultVideo}, expect: 0},
+		{name: "ac3 does not match e-ac-3", result: common.Result{Path: "a.ac3", Category: common.ResultAudio}, expect: 2},
+		{name: "eac3", result: common.Result{Path: "a.eac3", Category: common.ResultAudio}, expect: 1},
+		{name: "missing codec", result: common.Result{Path: "a.flac", Category: common.ResultAudio}, expect: -1},
+		{name: "tx3g subtitle", result: common.Result{Path: "a.ass", Category: common.ResultSubtitle}, isIso: true, expect: 3},
+		{name: "subrip in matroska", result: common.Result{Path: "a.srt", Category: common.ResultSubtitle}, expect: -1},
+	}
+
+	for _, test := range testList {
+		index := matchTrack(trackList, map[int]bool{}, test.result, test.isIso)
+		if index != test.expect {
+			t.Errorf("%s: got track %d, expect %d", test.name, index, test.expect)
+		}
+	}
+}
+
+func TestExpectedResultList(t *testing.T) {
+	resultList := []common.Result{
+		{Path: "a.hevc", Category: common.ResultVideo},
+		{Path: "a.srt", Category: common.ResultSubtitle},
+		{Path: "a.sup", Category: common.ResultSubtitle},
+		{Path: "a.txt", Category: common.ResultChapters},
+	}
+
+	testList := []struct {
+		outputPath string
+		expect     int
+	}{
+		{outputPath: "a.mkv", expect: 3},
+		{outputPath: "a.mp4", expect: 2},
+		{outputPath: "a.mov", expect: 2},
+	}
+
+	for _, test := range testList {
+		expectedList := expectedResultList(resultList, test.outputPath)
+		if len(expectedList) != test.expect {
+			t.Errorf("%s: got %d tracks, expect %d", test.outputPath, len(expectedList), test.expect)
+		}
+	}
+}
@@ -20,6 +20,7 @@ package mux
 
 import (
 	"MonitorEncoder/core/common"
+	"MonitorEncoder/core/report"
 	"MonitorEncoder/core/status"
 	"MonitorEncoder/core/worker"
 	"context"
@@ -89,12 +90,13 @@ func (w *Worker) workerLoop(ctx context.Context) {
 			exitFlag = true
 			continue
 		case task := <-w.InputStream:
-			if task.Mux == "" {
+			if len(task.Mux) <= 0 {
 				log.Printf("[info] %s bypass task: %s\n", w.GetPrettyName(), task.Src)
 			} else {
 				log.Printf("[info] %s handle task: %s\n", w.GetPrettyName(), task.Src)
 				err := w.handleNewTask(ctx, &task)
 				if err != nil {
+					report.Release(task.Src)
 					log.Printf("[error] %s encounter error during handle task %s: %s\n", w.GetPrettyName(), task.Src, err.Error())
 					continue
 				}
@@ -114,26 +116,77 @@ func (w *Worker) workerLoop(ctx context.Context) {
 
 func (w *Worker) handleNewTask(ctx context.Context, task *common.Task) error {
 	srcFile := task.Src
+	ctx = report.WithSrc(ctx, srcFile)
+	report.StartStage(srcFile, "mux")
+	defer report.FinishStage(srcFile, "mux")
 
 	status.SetStatusCode(srcFile, status.MUX)
-	status.SetStatusDesc(srcFile, "muxing " + task.Mux)
-
-	formatHandler, exist := formatHandlerMap[task.Mux]
-	if !exist {
-		errDesc := "unknown mux format: " + task.Mux
-		status.SetStatusCode(srcFile, status.ERROR)
-		status.SetStatusDesc(srcFile, errDesc)
-		return errors.New(errDesc)
-	}
 
-	outputPath, err := formatHandler(ctx, w.workDirPath, task)
-	if err != nil {
-		status.SetStatusCode(srcFile, status.ERROR)
-		status.SetStatusDesc(srcFile, err.Error())
-		return err
+	usedPathSet := make(map[string]bool)
+	muxedFileList := make([]string, 0, len(task.Mux))
+	for i := range task.Mux {
+		spec := &task.Mux[i]
+
+		format, exist := formatMap[spec.Format]
+		if !exist {
+			errDesc := "unknown mux format: " + spec.Format
+			status.SetStatusCode(srcFile, status.ERROR)
+			status.SetStatusDesc(srcFile, errDesc)
+			return errors.New(errDesc)
+		}
+
+		outputPath := w.newOutputPath(task, spec, format, i, usedPathSet)
+		resultList := filterResultList(task, spec)
+		if spec.Match(common.ResultAttachment, "", "") {
+			attachmentList, err := attachmentResultList(task, resultList)
+			if err != nil {
+				status.SetStatusCode(srcFile, status.ERROR)
+				status.SetStatusDesc(srcFile, err.Error())
+				return err
+			}
+			resultList = append(resultList, attachmentList...)
+		}
+
+		status.SetStatusDesc(srcFile, "muxing "+outputPath)
+		err := format.Handler(ctx, outputPath, task, resultList)
+		if err != nil {
+			status.SetStatusCode(srcFile, status.ERROR)
+			status.SetStatusDesc(srcFile, err.Error())
+			return err
+		}
+
+		if !task.SkipVerify {
+			status.SetStatusDesc(srcFile, "verifying "+outputPath)
+
+			report, err := verifyOutput(ctx, task, outputPath, resultList)
+			if err != nil {
+				errDesc := "verification failed: " + err.Error()
+				status.SetStatusCode(srcFile, status.ERROR)
+				status.SetStatusDesc(srcFile, errDesc)
+				return errors.New(errDesc)
+			}
+			status.AddStatusReport(srcFile, report)
+		}
+
+		muxedFileList = append(muxedFileList, outputPath)
 	}
 
-	task.MuxedFile = outputPath
+	task.MuxedFileList = muxedFileList
 
 	return nil
 }
+
+func (w *Worker) newOutputPath(task *common.Task, spec *common.MuxSpec, format *Format, index int, usedPathSet map[string]bool) string {
+	ext := format.Ext
+	if spec.Suffix != "" {
+		ext = spec.Suffix + "." + ext
+	}
+
+	outputPath := common.GenerateNewFilePath(task.Src, w.workDirPath, ext, "", 0)
+	if usedPathSet[outputPath] {
+		outputPath = common.GenerateNewFilePath(task.Src, w.workDirPath, fmt.Sprintf("%d.%s", index+1, ext), "", 0)
+	}
+	usedPathSet[outputPath] = true
+
+	return outputPath
+}
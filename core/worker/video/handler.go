@@ -20,13 +20,13 @@ package video
 
 import (
 	"MonitorEncoder/core/common"
+	"MonitorEncoder/core/report"
 	"MonitorEncoder/core/status"
 	"bufio"
 	"context"
 	"errors"
 	"fmt"
 	"io"
-	"os/exec"
 	"regexp"
 	"runtime"
 	"strings"
@@ -43,14 +43,14 @@ func handlerHEVC(ctx context.Context, scriptPath string, workDirPath string, tas
 	hevcFilePath := common.GenerateNewFilePath(task.Src, workDirPath, "hevc", "", 0)
 
 	baseX265Param := []string{"-D", "10", "--y4m", "--output", hevcFilePath, "-"}
-	extraX265Param := strings.Split(task.Param, " ")
+	extraX265Param := strings.Fields(task.Param)
 	x265Param := append(baseX265Param, extraX265Param...)
 
 	vspipePath := common.GetVspipePath()
-	vspipeProcess := exec.CommandContext(ctx, vspipePath, "-y", scriptPath, "-")
+	vspipeProcess := report.NewCommand(ctx, vspipePath, "-y", scriptPath, "-")
 
 	x265Path := common.GetX265Path()
-	x265Process := exec.CommandContext(ctx, x265Path, x265Param...)
+	x265Process := report.NewCommand(ctx, x265Path, x265Param...)
 	x265Process.Stdin, _ = vspipeProcess.StdoutPipe()
 	x265StdErr, _ := x265Process.StderrPipe()
 
@@ -112,14 +112,14 @@ func handlerAVC(ctx context.Context, scriptPath string, workDirPath string, task
 	avcFilePath := common.GenerateNewFilePath(task.Src, workDirPath, "264", "", 0)
 
 	baseX264Param := []string{"--demuxer", "y4m", "--output", avcFilePath, "-"}
-	extraX264Param := strings.Split(task.Param, " ")
+	extraX264Param := strings.Fields(task.Param)
 	x264Param := append(baseX264Param, extraX264Param...)
 
 	vspipePath := common.GetVspipePath()
-	vspipeProcess := exec.CommandContext(ctx, vspipePath, "-y", scriptPath, "-")
+	vspipeProcess := report.NewCommand(ctx, vspipePath, "-y", scriptPath, "-")
 
 	x264Path := common.GetX264Path()
-	x264Process := exec.CommandContext(ctx, x264Path, x264Param...)
+	x264Process := report.NewCommand(ctx, x264Path, x264Param...)
 	x264Process.Stdin, _ = vspipeProcess.StdoutPipe()
 	x264StdErr, _ := x264Process.StderrPipe()
 
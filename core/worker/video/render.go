@@ -0,0 +1,236 @@
+/*
+ * MonitorEncoder
+ * Copyright (C) 2021  kewenyu
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package video
+
+import (
+	"MonitorEncoder/core/bdmv"
+	"MonitorEncoder/core/common"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+const (
+	templateExt        = ".tmpl"
+	templateIncludeDir = "include"
+)
+
+type templateData struct {
+	Task       *common.Task
+	Src        string
+	HardSub    string
+	ClipList   []bdmv.ClipRange
+	Vars       map[string]interface{}
+	fpsNum     uint
+	fpsDen     uint
+	frameCount uint
+}
+
+var templateFuncMap = template.FuncMap{
+	"py": pythonLiteral,
+}
+
+func IsTemplateFile(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), templateExt)
+}
+
+func readTemplate(templatePath string, task *common.Task) ([]byte, error) {
+	if !IsTemplateFile(templatePath) {
+		data, err := ioutil.ReadFile(templatePath)
+		if err != nil {
+			return nil, errors.New("failed to open template file: " + err.Error())
+		}
+		return data, nil
+	}
+
+	return renderTemplate(templatePath, task)
+}
+
+func renderTemplate(templatePath string, task *common.Task) ([]byte, error) {
+	templateName := filepath.Base(templatePath)
+	tmpl, err := template.New(templateName).Funcs(templateFuncMap).Option("missingkey=error").ParseFiles(templatePath)
+	if err != nil {
+		return nil, errors.New("failed to parse template: " + err.Error())
+	}
+
+	includeList, err := filepath.Glob(filepath.Join(filepath.Dir(templatePath), templateIncludeDir, "*"+templateExt))
+	if err != nil {
+		return nil, errors.New("failed to list template includes: " + err.Error())
+	}
+	for _, includePath := range includeList {
+		if filepath.Base(includePath) == templateName {
+			return nil, fmt.Errorf("include %s has the same name as the template", includePath)
+		}
+	}
+	if len(includeList) > 0 {
+		tmpl, err = tmpl.ParseFiles(includeList...)
+		if err != nil {
+			return nil, errors.New("failed to parse template include: " + err.Error())
+		}
+	}
+
+	data, err := newTemplateData(task)
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	err = tmpl.ExecuteTemplate(&buffer, templateName, data)
+	if err != nil {
+		return nil, errors.New("failed to render template: " + err.Error())
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func newTemplateData(task *common.Task) (*templateData, error) {
+	clipRangeList, err := getClipRangeList(task)
+	if err != nil {
+		return nil, err
+	}
+
+	data := templateData{
+		Task:     task,
+		Src:      task.Src,
+		HardSub:  task.HardSub,
+		ClipList: clipRangeList,
+		Vars:     task.Vars,
+	}
+	if data.Vars == nil {
+		data.Vars = make(map[string]interface{})
+	}
+
+	if bdmv.IsPlaylist(task.Src) {
+		playlist, err := bdmv.ParsePlaylist(task.Src)
+		if err != nil {
+			return nil, err
+		}
+		data.fpsNum, data.fpsDen = playlist.FPS()
+		for _, clipRange := range clipRangeList {
+			data.frameCount += uint(clipRange.End - clipRange.Start)
+		}
+	} else {
+		if task.SourceInfo != nil {
+			data.fpsNum, data.fpsDen = task.SourceInfo.FPSNum, task.SourceInfo.FPSDen
+		}
+		data.frameCount = task.SourceFrameNum
+	}
+
+	return &data, nil
+}
+
+func (d *templateData) FPSNum() (uint, error) {
+	if d.fpsNum == 0 || d.fpsDen == 0 {
+		return 0, errors.New("unknown frame rate of " + d.Src)
+	}
+	return d.fpsNum, nil
+}
+
+func (d *templateData) FPSDen() (uint, error) {
+	if d.fpsNum == 0 || d.fpsDen == 0 {
+		return 0, errors.New("unknown frame rate of " + d.Src)
+	}
+	return d.fpsDen, nil
+}
+
+func (d *templateData) FrameCount() (uint, error) {
+	if d.frameCount == 0 {
+		return 0, errors.New("unknown frame count of " + d.Src + ", it is only known for playlists before indexing")
+	}
+	return d.frameCount, nil
+}
+
+func pythonString(s string) string {
+	if strings.ContainsAny(s, "\"\n\r") || strings.HasSuffix(s, "\\") {
+		return strconv.Quote(s)
+	}
+	return fmt.Sprintf("r\"%s\"", s)
+}
+
+func pythonLiteral(value interface{}) (string, error) {
+	if value == nil {
+		return "None", nil
+	}
+	if clipRange, ok := value.(bdmv.ClipRange); ok {
+		return formatClipRange(clipRange), nil
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return "None", nil
+		}
+		return pythonLiteral(v.Elem().Interface())
+	case reflect.Bool:
+		if v.Bool() {
+			return "True", nil
+		}
+		return "False", nil
+	case reflect.String:
+		return pythonString(v.String()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64), nil
+	case reflect.Slice, reflect.Array:
+		itemList := make([]string, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			item, err := pythonLiteral(v.Index(i).Interface())
+			if err != nil {
+				return "", err
+			}
+			itemList = append(itemList, item)
+		}
+		return "[" + strings.Join(itemList, ", ") + "]", nil
+	case reflect.Map:
+		keyList := make([]string, 0, v.Len())
+		itemMap := make(map[string]string, v.Len())
+		for _, key := range v.MapKeys() {
+			keyLiteral, err := pythonLiteral(key.Interface())
+			if err != nil {
+				return "", err
+			}
+			item, err := pythonLiteral(v.MapIndex(key).Interface())
+			if err != nil {
+				return "", err
+			}
+			keyList = append(keyList, keyLiteral)
+			itemMap[keyLiteral] = item
+		}
+		sort.Strings(keyList)
+
+		itemList := make([]string, 0, len(keyList))
+		for _, key := range keyList {
+			itemList = append(itemList, key+": "+itemMap[key])
+		}
+		return "{" + strings.Join(itemList, ", ") + "}", nil
+	}
+
+	return "", fmt.Errorf("can not convert %T to python", value)
+}
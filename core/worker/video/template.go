@@ -19,12 +19,15 @@
 package video
 
 import (
+	"MonitorEncoder/core/bdmv"
 	"MonitorEncoder/core/common"
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -32,6 +35,9 @@ var substitutionMap = map[string]func(string, *common.Task) (string, error){
 	"###INPUTFILE###": substitutionInput,
 	"###DEBUG###":     substitutionDebug,
 	"###SUBTITLE###":  substitutionSubtitle,
+	"###CLIPLIST###":  substitutionClipList,
+	"###TRIM###":      substitutionTrim,
+	"###FPS###":       substitutionFPS,
 }
 
 func GenerateVpyFile(workDirPath string, task *common.Task) (string, error) {
@@ -44,21 +50,30 @@ func GenerateVpyFile(workDirPath string, task *common.Task) (string, error) {
 		return "", errors.New("template path not exist")
 	}
 
-	templateFile, err := os.Open(templatePath)
+	vpyFilePath := common.GenerateNewFilePath(task.Src, workDirPath, "vpy", "", 0)
+	return generateVpyFile(vpyFilePath, task, substitutionMap)
+}
+
+func generateUntrimmedVpyFile(workDirPath string, task *common.Task) (string, error) {
+	untrimmedMap := make(map[string]func(string, *common.Task) (string, error), len(substitutionMap))
+	for k, v := range substitutionMap {
+		untrimmedMap[k] = v
+	}
+	untrimmedMap["###TRIM###"] = substitutionUntrimmed
+
+	vpyFilePath := common.GenerateNewFilePath(task.Src, workDirPath, "untrimmed.vpy", "", 0)
+	return generateVpyFile(vpyFilePath, task, untrimmedMap)
+}
+
+func generateVpyFile(vpyFilePath string, task *common.Task, substitutionMap map[string]func(string, *common.Task) (string, error)) (string, error) {
+	templateData, err := readTemplate(task.Template, task)
 	if err != nil {
-		return "", errors.New("failed to open template file: " + err.Error())
+		return "", err
 	}
-	defer func() {
-		closeErr := templateFile.Close()
-		if err == nil {
-			err = closeErr
-		}
-	}()
 
-	vpyFilePath := common.GenerateNewFilePath(task.Src, workDirPath, "vpy", "", 0)
 	vpyFile, err2 := os.Create(vpyFilePath)
 	if err2 != nil {
-		return "", errors.New("failed to create vpy file: " + err.Error())
+		return "", errors.New("failed to create vpy file: " + err2.Error())
 	}
 	defer func() {
 		closeErr := vpyFile.Close()
@@ -68,7 +83,7 @@ func GenerateVpyFile(workDirPath string, task *common.Task) (string, error) {
 	}()
 
 	substitution := substitutionCopy
-	templateScanner := bufio.NewScanner(templateFile)
+	templateScanner := bufio.NewScanner(bytes.NewReader(templateData))
 	for templateScanner.Scan() {
 		line := templateScanner.Text()
 
@@ -132,6 +147,101 @@ func substitutionSubtitle(line string, task *common.Task) (string, error) {
 	return newLine, nil
 }
 
+func substitutionClipList(line string, task *common.Task) (string, error) {
+	clipVarReg := regexp.MustCompile(`(\w+)\s*=\s*\S+`)
+	clipVarMatch := clipVarReg.FindStringSubmatch(line)
+	if len(clipVarMatch) != 2 {
+		return "", errors.New("failed to match template's clip list variable")
+	}
+
+	clipRangeList, err := getClipRangeList(task)
+	if err != nil {
+		return "", err
+	}
+
+	clipList := make([]string, 0, len(clipRangeList))
+	for _, clipRange := range clipRangeList {
+		clipList = append(clipList, formatClipRange(clipRange))
+	}
+
+	clipVar := clipVarMatch[1]
+	newLine := fmt.Sprintf("%s = [%s]\n", clipVar, strings.Join(clipList, ", "))
+
+	return newLine, nil
+}
+
+func getClipRangeList(task *common.Task) ([]bdmv.ClipRange, error) {
+	if !bdmv.IsPlaylist(task.Src) {
+		return []bdmv.ClipRange{{Path: task.Src}}, nil
+	}
+
+	playlist, err := bdmv.ParsePlaylist(task.Src)
+	if err != nil {
+		return nil, err
+	}
+	return playlist.ClipRangeList()
+}
+
+func formatClipRange(clipRange bdmv.ClipRange) string {
+	end := "None"
+	if clipRange.End != 0 {
+		end = strconv.Itoa(clipRange.End)
+	}
+	return fmt.Sprintf("(%s, %d, %s)", pythonString(clipRange.Path), clipRange.Start, end)
+}
+
+func substitutionTrim(line string, task *common.Task) (string, error) {
+	trimVarReg := regexp.MustCompile(`(\w+)\s*=\s*(.+)`)
+	trimVarMatch := trimVarReg.FindStringSubmatch(line)
+	if len(trimVarMatch) != 3 {
+		return "", errors.New("failed to match template's trim variable")
+	}
+
+	trimList, err := common.ParseTrimList(trimVarMatch[2])
+	if err != nil {
+		return "", err
+	}
+	task.TrimList = trimList
+
+	trimVar := trimVarMatch[1]
+	newLine := fmt.Sprintf("%s = %s\n", trimVar, common.FormatTrimList(trimList))
+
+	return newLine, nil
+}
+
+func substitutionUntrimmed(line string, _ *common.Task) (string, error) {
+	trimVarReg := regexp.MustCompile(`(\w+)\s*=\s*(.+)`)
+	trimVarMatch := trimVarReg.FindStringSubmatch(line)
+	if len(trimVarMatch) != 3 {
+		return "", errors.New("failed to match template's trim variable")
+	}
+
+	trimVar := trimVarMatch[1]
+	newLine := fmt.Sprintf("%s = %s\n", trimVar, common.FormatTrimList([]common.Trim{{Start: 0, End: 0}}))
+
+	return newLine, nil
+}
+
+func substitutionFPS(line string, task *common.Task) (string, error) {
+	fpsVarReg := regexp.MustCompile(`(\w+)\s*=\s*(.+)`)
+	fpsVarMatch := fpsVarReg.FindStringSubmatch(line)
+	if len(fpsVarMatch) != 3 {
+		return "", errors.New("failed to match template's fps variable")
+	}
+
+	fpsNum, fpsDen, err := common.ParseFPS(fpsVarMatch[2])
+	if err != nil {
+		return "", err
+	}
+	task.TargetFPSNum = fpsNum
+	task.TargetFPSDen = fpsDen
+
+	fpsVar := fpsVarMatch[1]
+	newLine := fmt.Sprintf("%s = (%d, %d)\n", fpsVar, fpsNum, fpsDen)
+
+	return newLine, nil
+}
+
 func substitutionCopy(line string, _ *common.Task) (string, error) {
 	return fmt.Sprintf("%s\n", line), nil
 }
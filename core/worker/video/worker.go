@@ -21,14 +21,15 @@ package video
 import (
 	"MonitorEncoder/core/activetime"
 	"MonitorEncoder/core/common"
+	"MonitorEncoder/core/report"
 	"MonitorEncoder/core/status"
+	"MonitorEncoder/core/taskctx"
 	"MonitorEncoder/core/worker"
 	"context"
 	"errors"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"regexp"
 	"runtime"
 	"strconv"
@@ -100,13 +101,19 @@ func (w *Worker) workerLoop(ctx context.Context) {
 			exitFlag = true
 			continue
 		case task := <-w.InputStream:
-			log.Printf("[info] %s handle task: %s\n", w.GetPrettyName(), task.Src)
-			err := w.handleNewTask(ctx, &task)
-			if err != nil {
-				log.Printf("[error] %s encounter error during handle task %s: %s", w.GetPrettyName(), task.Src, err.Error())
-				continue
+			taskCtx := taskctx.Get(ctx, task.Src)
+			if taskCtx.Err() != nil {
+				log.Printf("[info] %s skip canceled task: %s\n", w.GetPrettyName(), task.Src)
+			} else {
+				log.Printf("[info] %s handle task: %s\n", w.GetPrettyName(), task.Src)
+				err := w.handleNewTask(taskCtx, &task)
+				if err != nil {
+					taskctx.Cancel(task.Src)
+					log.Printf("[error] %s encounter error during handle task %s: %s", w.GetPrettyName(), task.Src, err.Error())
+				} else {
+					log.Printf("[info] %s finish task: %s\n", w.GetPrettyName(), task.Src)
+				}
 			}
-			log.Printf("[info] %s finish task: %s\n", w.GetPrettyName(), task.Src)
 
 			select {
 			case <-ctx.Done():
@@ -122,6 +129,7 @@ func (w *Worker) workerLoop(ctx context.Context) {
 
 func (w *Worker) handleNewTask(ctx context.Context, task *common.Task) error {
 	srcFile := task.Src
+	ctx = report.WithSrc(ctx, srcFile)
 	if _, err := os.Stat(srcFile); os.IsNotExist(err) {
 		errDesc := fmt.Sprintf("src file not exist: %s", srcFile)
 		status.SetStatusCode(srcFile, status.ERROR)
@@ -138,73 +146,126 @@ func (w *Worker) handleNewTask(ctx context.Context, task *common.Task) error {
 		return errors.New(errDesc)
 	}
 
-	scriptPath, err := GenerateVpyFile(w.workDirPath, task)
+	if task.ScriptFile == "" {
+		scriptPath, err := GenerateVpyFile(w.workDirPath, task)
+		if err != nil {
+			status.SetStatusCode(srcFile, status.ERROR)
+			status.SetStatusDesc(srcFile, err.Error())
+			return err
+		}
+
+		task.ScriptFile = scriptPath
+	}
+
+	status.SetStatusCode(srcFile, status.VIDEO)
+
+	if task.TotalFrameNum == 0 {
+		status.SetStatusDesc(srcFile, "indexing")
+
+		report.StartStage(srcFile, "index")
+		err := IndexTask(ctx, task.ScriptFile, task)
+		report.FinishStage(srcFile, "index")
+		if err != nil {
+			status.SetStatusCode(srcFile, status.ERROR)
+			status.SetStatusDesc(srcFile, "indexing failed: "+err.Error())
+			return err
+		}
+	}
+
+	report.StartStage(srcFile, "encode")
+	resultPath, err := codecHandler(ctx, task.ScriptFile, w.workDirPath, task)
+	report.FinishStage(srcFile, "encode")
 	if err != nil {
 		status.SetStatusCode(srcFile, status.ERROR)
 		status.SetStatusDesc(srcFile, err.Error())
 		return err
 	}
 
-	task.ScriptFile = scriptPath
+	result := common.NewResult(resultPath, common.ResultVideo, "", 0)
+	result.Name = task.VideoName
+	result.Default = task.VideoDefault
+	result.Forced = task.VideoForced
+	task.AddResult(result)
 
-	status.SetStatusCode(srcFile, status.VIDEO)
-	status.SetStatusDesc(srcFile, "indexing")
+	return nil
+}
 
-	err = indexTask(ctx, scriptPath, task)
+func IndexTask(ctx context.Context, scriptPath string, task *common.Task) error {
+	totalFrameNum, fpsNum, fpsDen, err := readScriptInfo(ctx, scriptPath)
 	if err != nil {
-		status.SetStatusCode(srcFile, status.ERROR)
-		status.SetStatusDesc(srcFile, "indexing failed: "+err.Error())
 		return err
 	}
 
-	resultPath, err := codecHandler(ctx, scriptPath, w.workDirPath, task)
+	if totalFrameNum > 0 {
+		task.TotalFrameNum = totalFrameNum
+	}
+	if fpsNum > 0 && fpsDen > 0 {
+		task.FPSNum = fpsNum
+		task.FPSDen = fpsDen
+	}
+
+	return nil
+}
+
+func IndexSource(ctx context.Context, workDirPath string, task *common.Task) error {
+	if len(task.TrimList) <= 0 {
+		task.SourceFrameNum = task.TotalFrameNum
+		return nil
+	}
+
+	scriptPath, err := generateUntrimmedVpyFile(workDirPath, task)
 	if err != nil {
-		status.SetStatusCode(srcFile, status.ERROR)
-		status.SetStatusDesc(srcFile, err.Error())
 		return err
 	}
+	defer func() {
+		_ = os.Remove(scriptPath)
+	}()
 
-	task.AddResult(common.NewResult(resultPath, common.ResultVideo, "", 0))
+	totalFrameNum, _, _, err := readScriptInfo(ctx, scriptPath)
+	if err != nil {
+		return err
+	}
+	if totalFrameNum == 0 {
+		return errors.New("failed to read the frame count of the untrimmed source")
+	}
+	task.SourceFrameNum = totalFrameNum
 
 	return nil
 }
 
-func indexTask(ctx context.Context, scriptPath string, task *common.Task) error {
+func readScriptInfo(ctx context.Context, scriptPath string) (uint, uint, uint, error) {
 	vspipePath := common.GetVspipePath()
-	vspipeProcess := exec.CommandContext(ctx, vspipePath, "-i", scriptPath, "-")
+	vspipeProcess := report.NewCommand(ctx, vspipePath, "-i", scriptPath, "-")
 	data, err := vspipeProcess.Output()
 	if err != nil {
-		return err
+		return 0, 0, 0, err
 	}
 
+	var totalFrameNum, fpsNum, fpsDen uint
+
 	frameNumRegExp := regexp.MustCompile(`Frames:\s*(\d+)`)
 	frameNumMatch := frameNumRegExp.FindStringSubmatch(string(data))
 	if len(frameNumMatch) == 2 {
-		totalFrameNum, err := strconv.ParseUint(frameNumMatch[1], 10, 32)
+		frameNum, err := strconv.ParseUint(frameNumMatch[1], 10, 32)
 		if err != nil {
-			return err
-		} else {
-			task.TotalFrameNum = uint(totalFrameNum)
+			return 0, 0, 0, err
 		}
+		totalFrameNum = uint(frameNum)
 	}
 
 	fpsRegExp := regexp.MustCompile(`FPS:\s*(\d+)/(\d+)`)
 	fpsMatch := fpsRegExp.FindStringSubmatch(string(data))
 	if len(fpsMatch) == 3 {
-		fpsNum, err := strconv.ParseUint(fpsMatch[1], 10, 32)
+		num, err := strconv.ParseUint(fpsMatch[1], 10, 32)
 		if err != nil {
-			return err
-		} else {
-			task.FPSNum = uint(fpsNum)
+			return 0, 0, 0, err
 		}
-
-		fpsDen, err := strconv.ParseUint(fpsMatch[2], 10, 32)
+		den, err := strconv.ParseUint(fpsMatch[2], 10, 32)
 		if err != nil {
-			return err
-		} else {
-			task.FPSDen = uint(fpsDen)
+			return 0, 0, 0, err
 		}
+		fpsNum, fpsDen = uint(num), uint(den)
 	}
 
-	return nil
+	return totalFrameNum, fpsNum, fpsDen, nil
 }
@@ -20,28 +20,52 @@ package web
 
 import (
 	"MonitorEncoder/core/common"
+	"MonitorEncoder/core/probe"
 	"MonitorEncoder/core/status"
+	"MonitorEncoder/core/worker/mux"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
 	"os"
+	"strings"
 )
 
+var taskContentTypeMap = map[string]string{
+	"":                   ".json",
+	"application/json":   ".json",
+	"text/json":          ".json",
+	"application/yaml":   ".yaml",
+	"application/x-yaml": ".yaml",
+	"text/yaml":          ".yaml",
+	"text/x-yaml":        ".yaml",
+	"application/toml":   ".toml",
+	"text/toml":          ".toml",
+}
+
 var (
-	addr        string
-	isRunning   bool
-	monitorPath string
+	addr          string
+	isRunning     bool
+	monitorPath   string
+	presetDirPath string
 )
 
+type probeResponse struct {
+	Source  *common.SourceInfo `json:"source"`
+	Task    *common.Task       `json:"task"`
+	Warning string             `json:"warning,omitempty"`
+}
+
 func StartWeb(param *common.Parameter) error {
 	if _, err := os.Stat(param.MonitorDirPath); os.IsNotExist(err) {
 		return errors.New("monitor dir path not exist")
 	}
 
 	monitorPath = param.MonitorDirPath
+	presetDirPath = param.PresetDirPath
 
 	if isRunning == true {
 		return errors.New("http interface already running")
@@ -57,7 +81,10 @@ func StartWeb(param *common.Parameter) error {
 
 		http.HandleFunc("/status", pageStatus)
 		http.HandleFunc("/api/status", apiStatus)
+		http.HandleFunc("/api/batch", apiBatch)
 		http.HandleFunc("/api/newtask", apiNewTask)
+		http.HandleFunc("/api/probe", apiProbe)
+		http.HandleFunc("/newtask", pageNewTask)
 
 		err := http.ListenAndServe(addr, nil)
 		if err != nil {
@@ -80,25 +107,53 @@ func pageStatus(w http.ResponseWriter, r *http.Request) {
 		}
 
 		output := "<h1>Monitor Encoder Status List</h1>\n"
+		output += "<p><a href=\"/newtask\">New Task</a></p>\n"
 		output += "<table border=\"1\">\n"
 		output += "<tr>\n"
 		output += "<th>Id</th>\n"
 		output += "<th>Source File</th>\n"
+		output += "<th>Batch</th>\n"
 		output += "<th>Status Code</th>\n"
 		output += "<th>Detail</th>\n"
+		output += "<th>Report</th>\n"
 		output += "</tr>\n"
 
 		for _, data := range dataList {
 			output += "<tr>\n"
 			output += fmt.Sprintf("<th>%d</th>\n", data.Id)
 			output += fmt.Sprintf("<th>%s</th>\n", data.SrcFile)
+			output += fmt.Sprintf("<th>%s</th>\n", data.Batch)
 			output += fmt.Sprintf("<th>%d</th>\n", data.Code)
 			output += fmt.Sprintf("<th>%s</th>\n", data.Desc)
+			output += fmt.Sprintf("<th>%s</th>\n", strings.Join(data.Report, "<br>"))
 			output += "</tr>\n"
 		}
 
 		output += "</table>\n"
 
+		batchList := status.GetAllBatchStatus()
+		if len(batchList) > 0 {
+			output += "<h2>Batch List</h2>\n"
+			output += "<table border=\"1\">\n"
+			output += "<tr>\n"
+			output += "<th>Batch</th>\n"
+			output += "<th>Done</th>\n"
+			output += "<th>Failed</th>\n"
+			output += "<th>Total</th>\n"
+			output += "</tr>\n"
+
+			for _, batch := range batchList {
+				output += "<tr>\n"
+				output += fmt.Sprintf("<th>%s</th>\n", batch.Batch)
+				output += fmt.Sprintf("<th>%d</th>\n", batch.Done)
+				output += fmt.Sprintf("<th>%d</th>\n", batch.Error)
+				output += fmt.Sprintf("<th>%d</th>\n", batch.Total)
+				output += "</tr>\n"
+			}
+
+			output += "</table>\n"
+		}
+
 		_, _ = w.Write([]byte(output))
 	}
 }
@@ -115,6 +170,18 @@ func apiStatus(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func apiBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		w.Header().Set("Content-Type", "application/json")
+		data, err := json.Marshal(status.GetAllBatchStatus())
+		if err != nil {
+			_, _ = w.Write([]byte("error: " + err.Error()))
+			return
+		}
+		_, _ = w.Write(data)
+	}
+}
+
 func apiNewTask(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "POST" {
 		body, err := ioutil.ReadAll(r.Body)
@@ -123,34 +190,170 @@ func apiNewTask(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		var task common.Task
-		err = json.Unmarshal(body, &task)
+		contentType := r.Header.Get("Content-Type")
+		if contentType != "" {
+			contentType, _, err = mime.ParseMediaType(contentType)
+			if err != nil {
+				_, _ = w.Write([]byte(err.Error()))
+				return
+			}
+		}
+
+		ext, exist := taskContentTypeMap[contentType]
+		if !exist {
+			_, _ = w.Write([]byte("unsupported task content type: " + contentType))
+			return
+		}
+
+		taskList, err := common.NewTasksFromData(body, ext, "http")
 		if err != nil {
 			_, _ = w.Write([]byte(err.Error()))
 			return
 		}
 
-		scriptFilePath := common.GenerateNewFilePath(task.Src, monitorPath, "json", "", 0)
-		jsonFile, err := os.OpenFile(scriptFilePath, os.O_RDWR|os.O_CREATE, 0777)
+		task := taskList[0]
+		if task.Src == "" {
+			_, _ = w.Write([]byte("task src is empty"))
+			return
+		}
+
+		taskFilePath := common.GenerateNewFilePath(task.Src, monitorPath, strings.TrimPrefix(ext, "."), "", 0)
+		taskFile, err := os.OpenFile(taskFilePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0777)
 		if err != nil {
 			_, _ = w.Write([]byte(err.Error()))
 			return
 		}
 		defer func() {
-			closeErr := jsonFile.Close()
+			closeErr := taskFile.Close()
 			if err == nil {
 				err = closeErr
 			}
 		}()
 
-		_, err = jsonFile.Write(body)
+		_, err = taskFile.Write(body)
 		if err != nil {
 			_, _ = w.Write([]byte(err.Error()))
 			return
 		}
 
 		succMsg := fmt.Sprintf("new task added via http REST api: %s", task.Src)
+		if len(taskList) > 1 {
+			succMsg = fmt.Sprintf("new batch added via http REST api: %d tasks", len(taskList))
+		}
 		_, _ = w.Write([]byte(succMsg))
 		log.Printf("[info] %s\n", succMsg)
 	}
 }
+
+func apiProbe(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		w.Header().Set("Content-Type", "application/json")
+
+		src := r.URL.Query().Get("src")
+		if src == "" {
+			writeJsonError(w, http.StatusBadRequest, "src is empty")
+			return
+		}
+
+		sourceInfo, err := probe.Probe(r.Context(), src)
+		if err != nil {
+			writeJsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		task := common.Task{
+			Src:    src,
+			Preset: r.URL.Query().Get("preset"),
+		}
+
+		err = common.ApplyPreset(&task, presetDirPath)
+		if err != nil {
+			writeJsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if len(task.Audio) <= 0 && len(task.Demux) <= 0 {
+			suggestTracks(&task, sourceInfo)
+		}
+
+		resp := probeResponse{
+			Source: sourceInfo,
+			Task:   &task,
+		}
+
+		task.SourceInfo = sourceInfo
+		err = task.ResolveTracks()
+		if err == nil {
+			mux.ResolveDelayMode(&task)
+			err = mux.ValidateTask(&task)
+		}
+		if err != nil {
+			resp.Warning = err.Error()
+		}
+
+		data, err := json.MarshalIndent(resp, "", "    ")
+		if err != nil {
+			writeJsonError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		_, _ = w.Write(data)
+	}
+}
+
+func suggestTracks(task *common.Task, sourceInfo *common.SourceInfo) {
+	for _, trackInfo := range sourceInfo.TrackList {
+		if trackInfo.Type == common.TrackAudio {
+			task.Audio = append(task.Audio, common.AudioTask{
+				Track:    trackInfo.Track,
+				Codec:    "flac",
+				Language: trackInfo.Language,
+			})
+		} else if trackInfo.Type == common.TrackSubtitle && trackInfo.Codec == "pgs" {
+			task.Demux = append(task.Demux, common.DemuxTask{
+				Track:    trackInfo.Track,
+				Format:   "sup",
+				Language: trackInfo.Language,
+			})
+		}
+	}
+}
+
+func writeJsonError(w http.ResponseWriter, code int, msg string) {
+	data, _ := json.Marshal(map[string]string{"error": msg})
+	w.WriteHeader(code)
+	_, _ = w.Write(data)
+}
+
+func pageNewTask(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		output := "<h1>Monitor Encoder New Task</h1>\n"
+		output += "<p><a href=\"/status\">Status</a></p>\n"
+		output += "<p>\n"
+		output += "Source: <input id=\"src\" size=\"80\">\n"
+		output += "Preset: <input id=\"preset\" size=\"30\">\n"
+		output += "<button onclick=\"probeSrc()\">Probe</button>\n"
+		output += "</p>\n"
+		output += "<pre id=\"tracks\"></pre>\n"
+		output += "<p><textarea id=\"task\" rows=\"30\" cols=\"100\"></textarea></p>\n"
+		output += "<p><button onclick=\"submitTask()\">Submit</button></p>\n"
+		output += "<pre id=\"result\"></pre>\n"
+		output += "<script>\n"
+		output += "function probeSrc() {\n"
+		output += "  var query = \"src=\" + encodeURIComponent(document.getElementById(\"src\").value) + \"&preset=\" + encodeURIComponent(document.getElementById(\"preset\").value);\n"
+		output += "  document.getElementById(\"result\").textContent = \"probing...\";\n"
+		output += "  fetch(\"/api/probe?\" + query).then(function(resp) { return resp.json(); }).then(function(data) {\n"
+		output += "    if (data.error) { document.getElementById(\"result\").textContent = data.error; return; }\n"
+		output += "    document.getElementById(\"tracks\").textContent = data.source.tracks.map(function(t) { return t.track + \": \" + t.description; }).join(\"\\n\");\n"
+		output += "    document.getElementById(\"task\").value = JSON.stringify(data.task, null, 4);\n"
+		output += "    document.getElementById(\"result\").textContent = data.warning || \"\";\n"
+		output += "  });\n"
+		output += "}\n"
+		output += "function submitTask() {\n"
+		output += "  fetch(\"/api/newtask\", {method: \"POST\", headers: {\"Content-Type\": \"application/json\"}, body: document.getElementById(\"task\").value})\n"
+		output += "    .then(function(resp) { return resp.text(); }).then(function(text) { document.getElementById(\"result\").textContent = text; });\n"
+		output += "}\n"
+		output += "</script>\n"
+
+		_, _ = w.Write([]byte(output))
+	}
+}